@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterm/pterm"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/pkg/p2p"
+)
+
+// Log backends of the log config key
+const (
+	logPterm = "pterm"
+	logText  = "text"
+	logJSON  = "json"
+)
+
+// newLogger returns the logger the configuration asks for
+func newLogger(cfg config.Config) (p2p.Logger, error) {
+	level, err := logger.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	switch cfg.Log {
+	case logPterm:
+		if level <= slog.LevelDebug {
+			pterm.EnableDebugMessages()
+		}
+		return p2p.TerminalLevelLogger(level), nil
+	case logText:
+		return p2p.SlogLogger(slog.New(slog.NewTextHandler(os.Stderr, options))), nil
+	case logJSON:
+		return p2p.SlogLogger(slog.New(slog.NewJSONHandler(os.Stderr, options))), nil
+	default:
+		return nil, fmt.Errorf("invalid log backend %q, want %s, %s or %s", cfg.Log, logPterm, logText, logJSON)
+	}
+}
+
+// runDaemon runs a node without the interactive menu until the process is
+// interrupted or terminated. The shared folder and the cluster come from
+// P2P_FOLDER and P2P_CLUSTER.
+func runDaemon(cfg config.Config, log p2p.Logger) error {
+	opts := p2p.FromConfig(cfg)
+	opts.Folder = os.Getenv("P2P_FOLDER")
+	opts.Peers = parseCluster(os.Getenv("P2P_CLUSTER"))
+	opts.Logger = log
+
+	if opts.Folder == "" {
+		return errors.New("P2P_FOLDER must name the shared folder when running headless")
+	}
+
+	n, err := p2p.New(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create node: %w", err)
+	}
+	if err := n.Start(); err != nil {
+		return err
+	}
+	log.Infof("Node %s sharing %s with %d initial peer(s)", n.ID(), opts.Folder, len(opts.Peers))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Infof("Shutting down")
+	n.Stop()
+	return nil
+}
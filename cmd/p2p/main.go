@@ -2,15 +2,34 @@ package main
 
 import (
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/pterm/pterm"
 	"github.com/pterm/pterm/putils"
 
-	"github.com/1995parham-teaching/P2P/internal/node"
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/pkg/p2p"
 )
 
 func main() {
+	cfg := config.Read()
+
+	log, err := newLogger(cfg)
+	if err != nil {
+		pterm.Error.Println(err)
+		os.Exit(1)
+	}
+
+	// Without the terminal UI the node runs headless
+	if cfg.Log != logPterm {
+		if err := runDaemon(cfg, log); err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Print header
 	_ = pterm.DefaultBigText.WithLetters(
 		putils.LettersFromStringWithStyle("P2P", pterm.NewStyle(pterm.FgCyan)),
@@ -25,27 +44,25 @@ func main() {
 	// Check for environment variables (for Docker/automated deployment)
 	folder := os.Getenv("P2P_FOLDER")
 	clusterEnv := os.Getenv("P2P_CLUSTER")
+	beaconEnv := os.Getenv("P2P_BEACON")
+	mdnsEnv, _ := strconv.ParseBool(os.Getenv("P2P_MDNS"))
 
 	var clusterList []string
 
-	if folder != "" && clusterEnv != "" {
+	// With beacons or mDNS on the cluster list may be empty, peers are found
+	// on the LAN
+	if folder != "" && (clusterEnv != "" || beaconEnv != "" || mdnsEnv) {
 		// Use environment variables
 		pterm.Info.Println("Using environment configuration")
 
 		_ = pterm.DefaultBulletList.WithItems([]pterm.BulletListItem{
 			{Level: 0, Text: "Folder: " + pterm.LightCyan(folder)},
 			{Level: 0, Text: "Cluster: " + pterm.LightCyan(clusterEnv)},
+			{Level: 0, Text: "Beacon: " + pterm.LightCyan(beaconEnv)},
+			{Level: 0, Text: "mDNS: " + pterm.LightCyan(strconv.FormatBool(mdnsEnv))},
 		}).Render()
 
-		// Parse cluster list from comma-separated string
-		if clusterEnv != "" {
-			for _, addr := range strings.Split(clusterEnv, ",") {
-				addr = strings.TrimSpace(addr)
-				if addr != "" {
-					clusterList = append(clusterList, addr)
-				}
-			}
-		}
+		clusterList = parseCluster(clusterEnv)
 	} else {
 		// Interactive mode
 		var err error
@@ -67,16 +84,24 @@ func main() {
 	pterm.Success.Println("Configuration complete!")
 	pterm.Println()
 
-	n, err := node.New(folder, clusterList)
+	opts := p2p.FromConfig(cfg)
+	opts.Folder = folder
+	opts.Peers = clusterList
+	opts.Events = newTerminal()
+	opts.Logger = log
+
+	n, err := p2p.New(opts)
 	if err != nil {
 		pterm.Error.Printf("Failed to create node: %v\n", err)
 		os.Exit(1)
 	}
 
-	if err := n.Run(); err != nil {
+	if err := n.Start(); err != nil {
 		pterm.Error.Printf("Node error: %v\n", err)
 		os.Exit(1)
 	}
+
+	newMenu(n).run()
 }
 
 func getFolder() (string, error) {
@@ -149,3 +174,15 @@ func getClusterMembers() ([]string, error) {
 
 	return cluster, nil
 }
+
+// parseCluster parses a comma-separated list of peer addresses
+func parseCluster(list string) []string {
+	var cluster []string
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			cluster = append(cluster, addr)
+		}
+	}
+	return cluster
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"github.com/1995parham-teaching/P2P/pkg/p2p"
+)
+
+const (
+	menuList   = "List cluster members"
+	menuScores = "Show peer scores"
+	menuShared = "List shared files"
+	menuSearch = "Search the cluster"
+	menuBrowse = "Browse peer"
+	menuGet    = "Download a file"
+	menuPing   = "Ping peers"
+	menuQuit   = "Quit"
+)
+
+// menu is the interactive front end of a node
+type menu struct {
+	node *p2p.Node
+
+	// Downloads run in the background until shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newMenu(node *p2p.Node) *menu {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &menu{node: node, ctx: ctx, cancel: cancel}
+}
+
+// run shows the menu until the user quits
+func (m *menu) run() {
+	options := []string{menuList, menuScores, menuShared, menuSearch, menuBrowse, menuGet, menuPing, menuQuit}
+
+	for {
+		pterm.Println()
+		selectedOption, err := pterm.DefaultInteractiveSelect.
+			WithOptions(options).
+			WithDefaultText("What would you like to do?").
+			Show()
+
+		if err != nil {
+			// Handle Ctrl+C or other interrupts
+			if err.Error() == "^C" {
+				m.shutdown()
+				return
+			}
+			pterm.Error.Printf("Error reading input: %v\n", err)
+			continue
+		}
+
+		switch selectedOption {
+		case menuList:
+			m.showClusterMembers()
+
+		case menuScores:
+			m.showScores()
+
+		case menuShared:
+			m.showSharedFiles()
+
+		case menuSearch:
+			m.searchCluster()
+
+		case menuBrowse:
+			m.browsePeer()
+
+		case menuGet:
+			m.downloadFile()
+
+		case menuPing:
+			m.pingPeers()
+
+		case menuQuit:
+			m.shutdown()
+			return
+		}
+	}
+}
+
+func (m *menu) showClusterMembers() {
+	members := m.node.Members()
+
+	pterm.Println()
+	if len(members) == 0 {
+		pterm.Warning.Println("No cluster members found")
+		return
+	}
+
+	// Create table data
+	tableData := pterm.TableData{
+		{"#", "Address", "ID", "Version", "TCP Port", "Status", "Last seen", "RTT"},
+	}
+
+	for i, member := range members {
+		status, rtt := "unknown", "-"
+		if !member.Pinged.IsZero() {
+			status = "unreachable"
+			if member.Reachable {
+				status = "reachable"
+				rtt = member.RTT.Round(time.Microsecond).String()
+			}
+		}
+		if member.State == p2p.Suspect {
+			status = "suspect"
+		}
+
+		id, version, tcpPort := "-", "-", "-"
+		if member.ID != "" {
+			id = member.ID
+			version = fmt.Sprintf("%d", member.Version)
+			tcpPort = fmt.Sprintf("%d", member.TCPPort)
+		}
+
+		tableData = append(tableData, []string{
+			fmt.Sprintf("%d", i+1),
+			member.Addr,
+			id,
+			version,
+			tcpPort,
+			status,
+			fmt.Sprintf("%s ago", time.Since(member.LastSeen).Round(time.Second)),
+			rtt,
+		})
+	}
+
+	_ = pterm.DefaultTable.
+		WithHasHeader().
+		WithBoxed().
+		WithData(tableData).
+		Render()
+
+	pterm.Info.Printf("Total: %d member(s)\n", len(members))
+}
+
+// showScores lists the reputation of the peers that served us files and
+// how long their own requests wait for an answer because of it
+func (m *menu) showScores() {
+	scores := m.node.Scores()
+
+	pterm.Println()
+	if len(scores) == 0 {
+		pterm.Warning.Println("No peer has served a file yet")
+		return
+	}
+
+	tableData := pterm.TableData{
+		{"#", "Peer", "Address", "Transfers", "Failures", "Throughput", "Score", "Response delay"},
+	}
+
+	for i, score := range scores {
+		tableData = append(tableData, []string{
+			fmt.Sprintf("%d", i+1),
+			score.Peer,
+			score.Addr,
+			fmt.Sprintf("%.1f", score.Transfers),
+			fmt.Sprintf("%.1f", score.Failures),
+			fmt.Sprintf("%.1f KiB/s", score.Throughput/1024),
+			fmt.Sprintf("%.2f", score.Score),
+			score.Delay.Round(time.Millisecond).String(),
+		})
+	}
+
+	_ = pterm.DefaultTable.
+		WithHasHeader().
+		WithBoxed().
+		WithData(tableData).
+		Render()
+
+	pterm.Info.Printf("Counts halve every %v, peers without a score wait %v\n", p2p.ScoreHalfLife, m.node.ResponseDelay())
+}
+
+// showSharedFiles lists the local files with the content links other peers
+// can use to fetch them regardless of their name
+func (m *menu) showSharedFiles() {
+	files := m.node.Shared()
+
+	pterm.Println()
+	if len(files) == 0 {
+		pterm.Warning.Println("No files shared")
+		return
+	}
+
+	pterm.Info.Printf("Total: %d file(s)\n", showFiles(files))
+}
+
+// searchCluster asks every peer for files matching a glob or substring,
+// shows the results grouped by content and downloads the chosen one
+func (m *menu) searchCluster() {
+	query, err := pterm.DefaultInteractiveTextInput.
+		WithDefaultText("").
+		Show("Enter a name, substring or glob pattern (e.g. *.pdf)")
+
+	if err != nil {
+		pterm.Error.Printf("Error: %v\n", err)
+		return
+	}
+
+	spinner, _ := pterm.DefaultSpinner.
+		WithRemoveWhenDone(true).
+		Start("Searching the cluster...")
+
+	hits, err := m.node.Search(m.ctx, query)
+
+	_ = spinner.Stop()
+
+	pterm.Println()
+	if err != nil {
+		pterm.Error.Printf("Search failed: %v\n", err)
+		return
+	}
+	if len(hits) == 0 {
+		pterm.Warning.Printf("No files matching '%s' found in the cluster\n", query)
+		return
+	}
+
+	tableData := pterm.TableData{
+		{"#", "Name", "Size", "Peers", "Link"},
+	}
+
+	files := make([]p2p.File, 0, len(hits))
+	for i, hit := range hits {
+		tableData = append(tableData, []string{
+			fmt.Sprintf("%d", i+1),
+			hit.Name,
+			fmt.Sprintf("%d", hit.Size),
+			fmt.Sprintf("%d", len(hit.Peers)),
+			hit.Link,
+		})
+		files = append(files, hit.File)
+	}
+
+	_ = pterm.DefaultTable.
+		WithHasHeader().
+		WithBoxed().
+		WithData(tableData).
+		Render()
+
+	pterm.Info.Printf("Total: %d file(s)\n", len(hits))
+
+	m.pickDownload(files)
+}
+
+// browsePeer shows the catalog of a single cluster member
+func (m *menu) browsePeer() {
+	members := m.node.Members()
+
+	if len(members) == 0 {
+		pterm.Warning.Println("No peers in cluster to browse")
+		return
+	}
+
+	addrs := make([]string, 0, len(members))
+	for _, member := range members {
+		addrs = append(addrs, member.Addr)
+	}
+
+	peer, err := pterm.DefaultInteractiveSelect.
+		WithOptions(addrs).
+		WithDefaultText("Which peer would you like to browse?").
+		Show()
+	if err != nil {
+		pterm.Error.Printf("Error: %v\n", err)
+		return
+	}
+
+	spinner, _ := pterm.DefaultSpinner.
+		WithRemoveWhenDone(true).
+		Start("Fetching catalog of " + peer + "...")
+
+	files, err := m.node.Browse(m.ctx, peer)
+
+	_ = spinner.Stop()
+
+	pterm.Println()
+	if err != nil {
+		pterm.Error.Printf("Failed to browse %s: %v\n", peer, err)
+		if len(files) == 0 {
+			return
+		}
+		pterm.Warning.Printf("Showing the %d file(s) received so far\n", len(files))
+	}
+
+	if len(files) == 0 {
+		pterm.Warning.Printf("Peer %s shares no files\n", peer)
+		return
+	}
+
+	pterm.Info.Printf("Total: %d file(s) shared by %s\n", showFiles(files), peer)
+
+	m.pickDownload(files)
+}
+
+// showFiles renders files as a table and returns how many there are
+func showFiles(files []p2p.File) int {
+	tableData := pterm.TableData{
+		{"#", "Name", "Size", "Link"},
+	}
+
+	for i, file := range files {
+		tableData = append(tableData, []string{
+			fmt.Sprintf("%d", i+1),
+			file.Name,
+			fmt.Sprintf("%d", file.Size),
+			file.Link,
+		})
+	}
+
+	_ = pterm.DefaultTable.
+		WithHasHeader().
+		WithBoxed().
+		WithData(tableData).
+		Render()
+
+	return len(files)
+}
+
+// pickDownload lets the user choose one of the listed files and downloads
+// it through its content link
+func (m *menu) pickDownload(files []p2p.File) {
+	const cancel = "Cancel"
+
+	options := make([]string, 0, len(files)+1)
+	for i, file := range files {
+		options = append(options, fmt.Sprintf("%d. %s", i+1, file.Name))
+	}
+	options = append(options, cancel)
+
+	selected, err := pterm.DefaultInteractiveSelect.
+		WithOptions(options).
+		WithDefaultText("Which file would you like to download?").
+		Show()
+	if err != nil || selected == cancel {
+		return
+	}
+
+	for i, option := range options {
+		if option == selected {
+			m.startDownload(files[i].Link)
+			return
+		}
+	}
+}
+
+func (m *menu) downloadFile() {
+	fileName, err := pterm.DefaultInteractiveTextInput.
+		WithDefaultText("").
+		Show("Enter filename or " + p2p.LinkPrefix + " link to download")
+
+	if err != nil {
+		pterm.Error.Printf("Error: %v\n", err)
+		return
+	}
+
+	if fileName == "" {
+		pterm.Warning.Println("No filename provided")
+		return
+	}
+
+	m.startDownload(fileName)
+}
+
+// startDownload looks up a file name or content link in the background
+// and downloads it from the peers that have it, the terminal events report
+// how it goes
+func (m *menu) startDownload(fileName string) {
+	pterm.Info.Printf("Requesting file: %s (searching in background)\n", fileName)
+
+	// Searches run in the background so several downloads can be in flight
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		_, _ = m.node.Download(m.ctx, fileName)
+	}()
+}
+
+func (m *menu) pingPeers() {
+	members := m.node.Members()
+
+	if len(members) == 0 {
+		pterm.Warning.Println("No peers in cluster to ping")
+		return
+	}
+
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Pinging %d peer(s)...", len(members)))
+
+	var wg sync.WaitGroup
+	reachable := make([]bool, len(members))
+
+	for i, member := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.node.Ping(m.ctx, member.Addr)
+			reachable[i] = err == nil
+		}()
+	}
+	wg.Wait()
+
+	up := 0
+	for _, ok := range reachable {
+		if ok {
+			up++
+		}
+	}
+	spinner.Success(fmt.Sprintf("%d of %d peer(s) answered", up, len(members)))
+
+	// Show current peers
+	m.showClusterMembers()
+}
+
+// shutdown gracefully stops the node
+func (m *menu) shutdown() {
+	pterm.Println()
+	spinner, _ := pterm.DefaultSpinner.Start("Shutting down...")
+
+	m.cancel()
+	m.node.Stop()
+
+	// Wait for the downloads to finish
+	m.wg.Wait()
+
+	spinner.Success("Shutdown complete")
+}
+
+// terminal reports the events of the node on the terminal
+type terminal struct {
+	p2p.NopEvents
+	// bars are the progress bars of the downloads in flight by ID,
+	// events come one at a time so they need no lock
+	bars map[uint64]*progressBar
+}
+
+// progressBar is the bar of one attempt at a download
+type progressBar struct {
+	*pterm.ProgressbarPrinter
+	attempt int
+}
+
+func newTerminal() *terminal {
+	return &terminal{bars: make(map[uint64]*progressBar)}
+}
+
+// DownloadProgress draws a progress bar, started over on every attempt
+func (t *terminal) DownloadProgress(p p2p.Progress) {
+	if bar, ok := t.bars[p.ID]; ok {
+		if bar.attempt == p.Attempt {
+			bar.Add(int(p.Received) - bar.Current)
+			return
+		}
+		_, _ = bar.Stop()
+	}
+
+	bar, _ := pterm.DefaultProgressbar.
+		WithTotal(int(p.Size)).
+		WithCurrent(int(p.Received)).
+		WithTitle("Downloading " + p.Query).
+		WithShowPercentage(true).
+		WithShowElapsedTime(true).
+		Start()
+	t.bars[p.ID] = &progressBar{ProgressbarPrinter: bar, attempt: p.Attempt}
+}
+
+// DownloadFinished removes the progress bar and reports the result
+func (t *terminal) DownloadFinished(id uint64, query string, result *p2p.Result, err error) {
+	if bar, ok := t.bars[id]; ok {
+		_, _ = bar.Stop()
+		delete(t.bars, id)
+	}
+
+	switch {
+	case err == nil:
+		pterm.Success.Printf("File saved: %s\n", result.Path)
+	case errors.Is(err, p2p.ErrNotFound):
+		pterm.Warning.Println(err)
+	case errors.Is(err, context.Canceled), errors.Is(err, p2p.ErrNotRunning):
+		pterm.Debug.Printf("Download of '%s' canceled\n", query)
+	default:
+		pterm.Error.Println(err)
+	}
+}
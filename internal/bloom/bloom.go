@@ -0,0 +1,97 @@
+package bloom
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// minBits keeps the filter of an empty or tiny set useful
+const minBits = 64
+
+var ErrInvalidFilter = errors.New("invalid bloom filter")
+
+// Filter is a Bloom filter of m bits probed by k hash functions. It answers
+// whether it may contain an item: false positives happen at the rate it was
+// sized for, false negatives never.
+type Filter struct {
+	bits []byte
+	k    int
+}
+
+// New returns a filter sized for n items at the false positive rate p,
+// with no more than maxBits bits. A set too large for maxBits gets a
+// higher false positive rate instead.
+func New(n int, p float64, maxBits int) *Filter {
+	n = max(n, 1)
+
+	m := int(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = min(max(m, minBits), maxBits)
+	m = (m + 7) / 8 * 8
+
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	k = min(max(k, 1), 16)
+
+	return &Filter{bits: make([]byte, m/8), k: k}
+}
+
+// Add adds item to the filter
+func (f *Filter) Add(item string) {
+	h1, h2 := hashes(item)
+	m := uint64(len(f.bits) * 8)
+	for i := range uint64(f.k) {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether item may have been added to the filter
+func (f *Filter) Test(item string) bool {
+	h1, h2 := hashes(item)
+	m := uint64(len(f.bits) * 8)
+	for i := range uint64(f.k) {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// String encodes the filter as the number of hash functions and the
+// base64url encoded bits, e.g. "7:AAAgAAEA..."
+func (f *Filter) String() string {
+	return strconv.Itoa(f.k) + ":" + base64.RawURLEncoding.EncodeToString(f.bits)
+}
+
+// Parse decodes a filter encoded by String
+func Parse(s string) (*Filter, error) {
+	ks, bits, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFilter, s)
+	}
+
+	k, err := strconv.Atoi(ks)
+	if err != nil || k < 1 || k > 16 {
+		return nil, fmt.Errorf("%w: %q hash functions", ErrInvalidFilter, ks)
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(bits)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("%w: bits %q", ErrInvalidFilter, bits)
+	}
+
+	return &Filter{bits: b, k: k}, nil
+}
+
+// hashes derives the two hashes the k probes are combined from, the second
+// one odd so the probes never collapse onto one bit
+func hashes(item string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(item))
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
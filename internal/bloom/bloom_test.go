@@ -0,0 +1,67 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNoFalseNegatives(t *testing.T) {
+	f := New(1000, 0.01, 1<<16)
+	for i := range 1000 {
+		f.Add(fmt.Sprintf("file-%d.txt", i))
+	}
+
+	for i := range 1000 {
+		if !f.Test(fmt.Sprintf("file-%d.txt", i)) {
+			t.Fatalf("Test(file-%d.txt) = false for an added item", i)
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	f := New(1000, 0.01, 1<<16)
+	for i := range 1000 {
+		f.Add(fmt.Sprintf("file-%d.txt", i))
+	}
+
+	positives := 0
+	for i := range 10000 {
+		if f.Test(fmt.Sprintf("other-%d.txt", i)) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / 10000; rate > 0.02 {
+		t.Errorf("false positive rate = %.3f, want about 0.01", rate)
+	}
+}
+
+func TestMaxBits(t *testing.T) {
+	f := New(100000, 0.01, 8192)
+	if len(f.bits)*8 != 8192 {
+		t.Errorf("filter has %d bits, want the cap of 8192", len(f.bits)*8)
+	}
+
+	if f := New(0, 0.01, 8192); len(f.bits)*8 != minBits {
+		t.Errorf("empty filter has %d bits, want %d", len(f.bits)*8, minBits)
+	}
+}
+
+func TestParse(t *testing.T) {
+	f := New(10, 0.01, 8192)
+	f.Add("movie.mkv")
+
+	parsed, err := Parse(f.String())
+	if err != nil {
+		t.Fatalf("Parse(%q) error: %v", f.String(), err)
+	}
+	if !parsed.Test("movie.mkv") || parsed.String() != f.String() {
+		t.Errorf("Parse(%q) = %q, want the same filter", f.String(), parsed.String())
+	}
+
+	for _, s := range []string{"", "7", "x:AAAA", "0:AAAA", "7:", "7:!!"} {
+		if _, err := Parse(s); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidFilter", s, err)
+		}
+	}
+}
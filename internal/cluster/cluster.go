@@ -2,24 +2,188 @@ package cluster
 
 import (
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"net"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
-	"github.com/pterm/pterm"
+	"github.com/1995parham-teaching/P2P/internal/bloom"
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/message"
 )
 
+// Timeouts is the failure detection policy. A suspected member that doesn't
+// refute the suspicion within Suspect is declared dead. A dead member is
+// remembered for Tombstone so stale gossip doesn't bring it back.
+type Timeouts struct {
+	Suspect   time.Duration
+	Tombstone time.Duration
+}
+
+// DefaultTimeouts suit the default 2 second probe period
+var DefaultTimeouts = Timeouts{
+	Suspect:   10 * time.Second,
+	Tombstone: 600 * time.Second,
+}
+
+// Peer is what the cluster knows about a member. Addr, Meta, State and
+// Incarnation are gossiped, the rest are our own observations.
+type Peer struct {
+	// Addr is the address the peer advertises, Addrs every address it is
+	// known by, resolved forms of hostnames included
+	Addr  string
+	Addrs []string
+	message.Meta
+	State       message.State
+	Incarnation uint64
+
+	// LastSeen is when a datagram from the peer last arrived
+	LastSeen time.Time
+	// RTT and Reachable are the outcome of the last ping, at Pinged
+	RTT       time.Duration
+	Reachable bool
+	Pinged    time.Time
+	// Successes and Failures count the pings the peer answered and didn't
+	Successes int
+	Failures  int
+	// Summary is the Bloom filter of its files the peer last sent, nil
+	// until it sends one
+	Summary *bloom.Filter
+}
+
+// Has reports whether addr is one of the peer's addresses
+func (p Peer) Has(addr string) bool {
+	return slices.Contains(p.Addrs, addr)
+}
+
+// MayHave reports whether the peer may share a file with the given name or
+// content hash, which is always the case before it sent its summary
+func (p Peer) MayHave(item string) bool {
+	return p.Summary == nil || p.Summary.Test(item)
+}
+
+type member struct {
+	Peer
+	// changed is when the member entered its current state
+	changed time.Time
+}
+
+// gossip is a membership update waiting to be piggybacked
+type gossip struct {
+	update    message.Update
+	transmits int
+}
+
+// Cluster is the membership list, maintained SWIM style: members are
+// probed one at a time, failures are suspected before they are declared,
+// and changes spread by piggybacking on the probe traffic. Members are
+// keyed by their node ID, so one node known under several addresses is a
+// single member. Addresses learned without an ID, like the initial list,
+// are adopted by the first member that turns out to use them.
 type Cluster struct {
-	list  []string
-	mutex sync.RWMutex
+	self        string
+	meta        message.Meta
+	incarnation uint64
+
+	members []*member
+	// dead members are kept as tombstones until they expire, keyed by ID
+	// or, for members we never learned the ID of, by address
+	dead     map[string]*member
+	queue    []*gossip
+	probes   []string
+	timeouts Timeouts
+	// known is the peer table as last stored, see Save
+	known map[string]record
+	// notify, if set, hears about members joining, being suspected,
+	// recovering and dying
+	notify func(Peer)
+	log    logger.Logger
+	mutex  sync.RWMutex
 }
 
-func New(list []string) *Cluster {
-	// Make a copy of the input list to avoid external mutations
-	listCopy := make([]string, len(list))
-	copy(listCopy, list)
+func New(list []string, log logger.Logger) *Cluster {
+	now := time.Now()
+
+	// Build our own members so later changes to list don't affect us
+	members := make([]*member, 0, len(list))
+	for _, addr := range list {
+		members = append(members, newMember(addr, addresses(addr), 0, now))
+	}
 
 	return &Cluster{
-		list: listCopy,
+		members:  members,
+		dead:     make(map[string]*member),
+		timeouts: DefaultTimeouts,
+		known:    make(map[string]record),
+		log:      log,
+	}
+}
+
+func newMember(addr string, addrs []string, incarnation uint64, now time.Time) *member {
+	return &member{
+		Peer: Peer{
+			Addr:        addr,
+			Addrs:       addrs,
+			State:       message.Alive,
+			Incarnation: incarnation,
+			LastSeen:    now,
+		},
+		changed: now,
+	}
+}
+
+// SetTimeouts replaces the failure detection policy
+func (c *Cluster) SetTimeouts(timeouts Timeouts) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.timeouts = timeouts
+}
+
+// SetSelf sets the address and meta this node is known by and announces
+// them. The incarnation starts at the current time, so a restarted node
+// outranks the tombstone of its previous run, and is raised whenever the
+// meta changes later on.
+func (c *Cluster) SetSelf(host string, meta message.Meta) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.self == "" {
+		c.incarnation = uint64(time.Now().Unix())
+	} else if c.self != host || c.meta != meta {
+		c.incarnation++
+	}
+
+	c.self = host
+	c.meta = meta
+	c.forget(host)
+	c.enqueue(c.selfUpdate())
+}
+
+// SetNotify sets the function that hears about every member that joins, is
+// suspected, recovers or dies. It is called with the cluster locked, so it
+// must neither block nor call back into the cluster.
+func (c *Cluster) SetNotify(notify func(Peer)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.notify = notify
+}
+
+// Self returns our own record as the other members see it
+func (c *Cluster) Self() Peer {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return Peer{
+		Addr:        c.self,
+		Addrs:       []string{c.self},
+		Meta:        c.meta,
+		State:       message.Alive,
+		Incarnation: c.incarnation,
+		Reachable:   true,
 	}
 }
 
@@ -28,9 +192,52 @@ func (c *Cluster) List() []string {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	listCopy := make([]string, len(c.list))
-	copy(listCopy, c.list)
-	return listCopy
+	list := make([]string, 0, len(c.members))
+	for _, m := range c.members {
+		list = append(list, m.Addr)
+	}
+	return list
+}
+
+// Peers returns a copy of the peer records
+func (c *Cluster) Peers() []Peer {
+	return c.Select(func(Peer) bool { return true })
+}
+
+// Select returns a copy of the peer records that match
+func (c *Cluster) Select(match func(Peer) bool) []Peer {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	peers := make([]Peer, 0, len(c.members))
+	for _, m := range c.members {
+		if match(m.Peer) {
+			peers = append(peers, m.copy())
+		}
+	}
+	return peers
+}
+
+// Lookup returns the record of the peer with the given address
+func (c *Cluster) Lookup(addr string) (Peer, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if m := c.find(addr); m != nil {
+		return m.copy(), true
+	}
+	return Peer{}, false
+}
+
+// LookupID returns the record of the peer with the given node ID
+func (c *Cluster) LookupID(id string) (Peer, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if m := c.findID(id); m != nil {
+		return m.copy(), true
+	}
+	return Peer{}, false
 }
 
 // Broadcast sends a message to all nodes in the cluster
@@ -43,74 +250,603 @@ func (c *Cluster) Broadcast(conn *net.UDPConn, message string) error {
 		addr, err := net.ResolveUDPAddr("udp", address)
 		if err != nil {
 			lastErr = fmt.Errorf("failed to resolve address %s: %w", address, err)
-			pterm.Error.Printf("Failed to resolve %s: %v\n", address, err)
+			c.log.Errorf("Failed to resolve %s: %v", address, err)
 			continue
 		}
 
 		_, err = conn.WriteToUDP([]byte(message), addr)
 		if err != nil {
 			lastErr = fmt.Errorf("failed to send to %s: %w", address, err)
-			pterm.Error.Printf("Failed to send to %s: %v\n", address, err)
+			c.log.Errorf("Failed to send to %s: %v", address, err)
 		}
 	}
 
 	return lastErr
 }
 
-// Merge adds new addresses to the cluster list, excluding duplicates and the host itself
+// Merge adds new addresses to the cluster list, excluding duplicates, the
+// host itself and dead addresses
 func (c *Cluster) Merge(host string, newList []string) {
+	updates := make([]message.Update, 0, len(newList))
+	for _, addr := range newList {
+		if addr != host {
+			updates = append(updates, message.Update{State: message.Alive, Addr: addr})
+		}
+	}
+	c.Apply(updates)
+}
+
+// Add adds a single address to the cluster if not already present. Unlike
+// Merge it also brings back a dead address.
+func (c *Cluster) Add(addr string) {
+	if addr == "" {
+		return
+	}
+	addrs := addresses(addr)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if key, _ := c.tombstone(message.Update{Addr: addr}); key != "" {
+		delete(c.dead, key)
+	}
+	if c.find(addr) == nil {
+		m := newMember(addr, addrs, 0, time.Now())
+		c.members = append(c.members, m)
+		c.log.Successf("Discovered new peer: %s", addr)
+		c.changed(m)
+	}
+}
+
+// Remove removes the member with the given address from the cluster
+func (c *Cluster) Remove(addr string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if m := c.find(addr); m != nil {
+		c.remove(m)
+	}
+}
+
+// Seen records that a datagram arrived from addr
+func (c *Cluster) Seen(addr string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if m := c.find(addr); m != nil {
+		m.LastSeen = time.Now()
+	}
+}
+
+// Pinged records the outcome of a ping to addr
+func (c *Cluster) Pinged(addr string, rtt time.Duration, reachable bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if m := c.find(addr); m != nil {
+		m.RTT = rtt
+		m.Reachable = reachable
+		m.Pinged = time.Now()
+		if reachable {
+			m.Successes++
+		} else {
+			m.Failures++
+		}
+	}
+}
+
+// Identify records that a datagram from addr was sent by the node with the
+// given ID. The address joins that member's address set, taking it over
+// from members known only by address. If the ID is our own, addr is one of
+// our own aliases and whoever was known by it is dropped.
+func (c *Cluster) Identify(addr, id string) {
+	if addr == "" || id == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if id == c.meta.ID {
+		for _, m := range slices.Clone(c.members) {
+			if m.ID == "" && m.Has(addr) {
+				c.log.Infof("%s is our own address, dropping it", m.Addr)
+				c.remove(m)
+			}
+		}
+		return
+	}
+
+	if m := c.findID(id); m != nil {
+		c.claim(m, []string{addr})
+	}
+}
+
+// Summarize records the file summary of the member with the given ID or,
+// when we don't know the ID yet, at the given address
+func (c *Cluster) Summarize(addr, id string, summary *bloom.Filter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var m *member
+	if id != "" {
+		m = c.findID(id)
+	}
+	if m == nil {
+		m = c.find(addr)
+	}
+	if m != nil {
+		m.Summary = summary
+	}
+}
+
+// Apply merges gossiped membership updates. Updates about ourselves that
+// claim we are suspect or dead are refuted by raising our incarnation.
+// Accepted updates are queued to be gossiped further.
+func (c *Cluster) Apply(updates []message.Update) {
+	// Resolve new addresses outside the lock, hostnames may need a DNS lookup
+	resolved := make(map[string][]string)
+
+	c.mutex.RLock()
+	for _, u := range updates {
+		if u.Addr != "" && u.State == message.Alive && c.find(u.Addr) == nil {
+			resolved[u.Addr] = nil
+		}
+	}
+	c.mutex.RUnlock()
+
+	for addr := range resolved {
+		resolved[addr] = addresses(addr)
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	for _, ip := range newList {
-		if ip == "" || ip == host {
+	now := time.Now()
+	for _, u := range updates {
+		if u.Addr == "" {
+			continue
+		}
+		if c.isSelf(u) {
+			c.refute(u)
+			continue
+		}
+		addrs := resolved[u.Addr]
+		if addrs == nil {
+			addrs = []string{u.Addr}
+		}
+		if c.self != "" && slices.Contains(addrs, c.self) {
+			// Another name for us, or someone else claiming our address.
+			// Either way nobody else can be reached there.
 			continue
 		}
 
-		if !contains(c.list, ip) {
-			c.list = append(c.list, ip)
-			pterm.Success.Printf("Discovered new peer: %s\n", ip)
+		switch u.State {
+		case message.Alive:
+			c.alive(u, addrs, now)
+		case message.Suspect:
+			c.suspect(u, now)
+		case message.Dead:
+			c.kill(u, now)
 		}
 	}
 }
 
-// Add adds a single address to the cluster if not already present
-func (c *Cluster) Add(addr string) {
+// Suspect marks a member that failed a probe as suspected and gossips it
+func (c *Cluster) Suspect(addr string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if addr != "" && !contains(c.list, addr) {
-		c.list = append(c.list, addr)
-		pterm.Success.Printf("Discovered new peer: %s\n", addr)
+	if m := c.find(addr); m != nil {
+		u := m.update()
+		u.State = message.Suspect
+		u.Meta = message.Meta{ID: m.ID}
+		c.suspect(u, time.Now())
 	}
 }
 
-// Remove removes an address from the cluster
-func (c *Cluster) Remove(addr string) {
+// Check applies the failure detection policy at now. Suspects that didn't
+// refute the suspicion in time are declared dead and returned, expired
+// tombstones are forgotten.
+func (c *Cluster) Check(now time.Time) []string {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	for i, ip := range c.list {
-		if ip == addr {
-			c.list = append(c.list[:i], c.list[i+1:]...)
-			return
+	for key, m := range c.dead {
+		if now.Sub(m.changed) >= c.timeouts.Tombstone {
+			delete(c.dead, key)
+		}
+	}
+
+	var dead []string
+	for _, m := range slices.Clone(c.members) {
+		if m.State == message.Suspect && now.Sub(m.changed) >= c.timeouts.Suspect {
+			u := m.update()
+			u.State = message.Dead
+			c.kill(u, now)
+			dead = append(dead, m.Addr)
+		}
+	}
+
+	return dead
+}
+
+// Gossip returns up to limit queued updates to piggyback on a message.
+// Updates sent the fewest times go first, and each is dropped once it has
+// been sent about log(n) times.
+func (c *Cluster) Gossip(limit int) []message.Update {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sort.SliceStable(c.queue, func(i, j int) bool {
+		return c.queue[i].transmits < c.queue[j].transmits
+	})
+
+	retransmits := config.GossipRetransmits * int(math.Ceil(math.Log10(float64(len(c.members)+2))))
+
+	updates := make([]message.Update, 0, limit)
+	queue := c.queue[:0]
+	for _, g := range c.queue {
+		if len(updates) < limit {
+			updates = append(updates, g.update)
+			g.transmits++
+		}
+		if g.transmits < retransmits {
+			queue = append(queue, g)
+		}
+	}
+	c.queue = queue
+
+	return updates
+}
+
+// State returns the whole membership as updates, ourselves and the
+// tombstones included, for a full state exchange with another member
+func (c *Cluster) State() []message.Update {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	updates := make([]message.Update, 0, len(c.members)+len(c.dead)+1)
+	if c.self != "" {
+		updates = append(updates, c.selfUpdate())
+	}
+	for _, m := range c.members {
+		updates = append(updates, m.update())
+	}
+	for _, m := range c.dead {
+		updates = append(updates, m.update())
+	}
+	return updates
+}
+
+// NextProbe returns the member to probe next. Members are probed round
+// robin in a random order that is reshuffled after every round, so each
+// one is probed once per round.
+func (c *Cluster) NextProbe() (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for {
+		if len(c.probes) == 0 {
+			if len(c.members) == 0 {
+				return "", false
+			}
+			for _, m := range c.members {
+				c.probes = append(c.probes, m.Addr)
+			}
+			rand.Shuffle(len(c.probes), func(i, j int) {
+				c.probes[i], c.probes[j] = c.probes[j], c.probes[i]
+			})
 		}
+
+		addr := c.probes[0]
+		c.probes = c.probes[1:]
+		if c.find(addr) != nil {
+			return addr, true
+		}
+	}
+}
+
+// Random returns up to k random members other than exclude
+func (c *Cluster) Random(k int, exclude string) []string {
+	candidates := make([]string, 0)
+	for _, addr := range c.List() {
+		if addr != exclude {
+			candidates = append(candidates, addr)
+		}
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
 	}
+	return candidates
 }
 
 // Size returns the number of nodes in the cluster
 func (c *Cluster) Size() int {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
-	return len(c.list)
+	return len(c.members)
 }
 
-// contains checks if a string slice contains a specific item
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
+// isSelf reports whether an update is about us. The caller must hold the
+// mutex.
+func (c *Cluster) isSelf(u message.Update) bool {
+	if u.ID != "" {
+		return u.ID == c.meta.ID
+	}
+	return c.self != "" && u.Addr == c.self
+}
+
+// refute answers gossip about ourselves. The caller must hold the mutex.
+func (c *Cluster) refute(u message.Update) {
+	if u.State == message.Alive || u.Incarnation < c.incarnation {
+		return
+	}
+
+	c.incarnation = u.Incarnation + 1
+	c.log.Warnf("Refuting rumor that we are %s", stateName(u.State))
+	c.enqueue(c.selfUpdate())
+}
+
+// selfUpdate announces ourselves. The caller must hold the mutex.
+func (c *Cluster) selfUpdate() message.Update {
+	return message.Update{State: message.Alive, Incarnation: c.incarnation, Addr: c.self, Meta: c.meta}
+}
+
+// update returns the gossiped part of a member's record
+func (m *member) update() message.Update {
+	u := message.Update{State: m.State, Incarnation: m.Incarnation, Addr: m.Addr}
+	if m.State == message.Alive {
+		u.Meta = m.Meta
+	} else {
+		u.ID = m.ID
+	}
+	return u
+}
+
+// copy returns the member's record without sharing its address set
+func (m *member) copy() Peer {
+	p := m.Peer
+	p.Addrs = slices.Clone(m.Addrs)
+	return p
+}
+
+// alive applies an Alive update. The caller must hold the mutex.
+func (c *Cluster) alive(u message.Update, addrs []string, now time.Time) {
+	m := c.match(u)
+	if m == nil {
+		if key, d := c.tombstone(u); d != nil {
+			if u.Incarnation <= d.Incarnation {
+				return
+			}
+			delete(c.dead, key)
 		}
+
+		m = newMember(u.Addr, nil, u.Incarnation, now)
+		m.Meta = u.Meta
+		c.claim(m, addrs)
+		c.members = append(c.members, m)
+		c.log.Successf("Discovered new peer: %s", u.Addr)
+		c.enqueue(m.update())
+		c.changed(m)
+		return
+	}
+
+	if u.Incarnation > m.Incarnation {
+		recovered := m.State == message.Suspect
+		if recovered {
+			c.log.Infof("Peer %s is alive again", m.Addr)
+		}
+		m.State = message.Alive
+		m.Incarnation = u.Incarnation
+		m.Addr = u.Addr
+		m.Meta = u.Meta
+		m.changed = now
+		c.claim(m, addrs)
+		c.enqueue(m.update())
+		if recovered {
+			c.changed(m)
+		}
+	}
+}
+
+// suspect applies a Suspect update. The caller must hold the mutex.
+func (c *Cluster) suspect(u message.Update, now time.Time) {
+	m := c.match(u)
+	if m == nil || u.Incarnation < m.Incarnation {
+		return
+	}
+	if m.State == message.Suspect && u.Incarnation == m.Incarnation {
+		return
+	}
+
+	suspected := m.State != message.Suspect
+	if suspected {
+		c.log.Warnf("Peer %s is suspected to have failed", m.Addr)
+		m.changed = now
+	}
+	m.State = message.Suspect
+	m.Incarnation = u.Incarnation
+	c.enqueue(m.update())
+	if suspected {
+		c.changed(m)
+	}
+}
+
+// kill applies a Dead update, leaving a tombstone. The caller must hold the
+// mutex.
+func (c *Cluster) kill(u message.Update, now time.Time) {
+	m := c.match(u)
+	if m == nil {
+		if _, d := c.tombstone(u); d != nil {
+			if u.Incarnation > d.Incarnation {
+				d.Incarnation = u.Incarnation
+			}
+			return
+		}
+		m = newMember(u.Addr, []string{u.Addr}, u.Incarnation, now)
+		m.ID = u.ID
+	} else if u.Incarnation < m.Incarnation {
+		return
+	} else {
+		c.remove(m)
+		c.log.Warnf("Peer %s is dead, removing it from the cluster", m.Addr)
+		defer c.changed(m)
+	}
+
+	m.State = message.Dead
+	m.Incarnation = u.Incarnation
+	m.changed = now
+	c.dead[key(m.update())] = m
+	c.enqueue(m.update())
+}
+
+// changed passes a member whose state changed to notify. The caller must
+// hold the mutex.
+func (c *Cluster) changed(m *member) {
+	if c.notify != nil {
+		c.notify(m.copy())
+	}
+}
+
+// enqueue queues an update for gossip, replacing any older update about
+// the same member. The caller must hold the mutex.
+func (c *Cluster) enqueue(u message.Update) {
+	c.queue = slices.DeleteFunc(c.queue, func(g *gossip) bool { return key(g.update) == key(u) })
+	c.queue = append(c.queue, &gossip{update: u})
+}
+
+// match returns the member an update is about: the one with its ID or,
+// for a member we don't know the ID of yet, the one with its address.
+// The caller must hold the mutex.
+func (c *Cluster) match(u message.Update) *member {
+	if u.ID == "" {
+		return c.find(u.Addr)
+	}
+	if m := c.findID(u.ID); m != nil {
+		return m
+	}
+	if m := c.find(u.Addr); m != nil && m.ID == "" {
+		// First word from a member we only knew by address
+		m.ID = u.ID
+		return m
+	}
+	return nil
+}
+
+// tombstone returns the tombstone an update is about along with its key.
+// An update without an ID matches a tombstone by address. The caller must
+// hold the mutex.
+func (c *Cluster) tombstone(u message.Update) (string, *member) {
+	if d, ok := c.dead[key(u)]; ok {
+		return key(u), d
+	}
+	if u.ID == "" {
+		for k, d := range c.dead {
+			if d.Has(u.Addr) {
+				return k, d
+			}
+		}
+	}
+	return "", nil
+}
+
+// claim adds addrs to a member's address set. A member known only by one
+// of them is the same node and is merged in, other members lose the
+// address and are dropped once they have none left. The caller must hold
+// the mutex.
+func (c *Cluster) claim(m *member, addrs []string) {
+	for _, addr := range addrs {
+		for _, other := range slices.Clone(c.members) {
+			if other == m || !other.Has(addr) {
+				continue
+			}
+
+			if other.ID == "" {
+				c.remove(other)
+				for _, a := range other.Addrs {
+					if !m.Has(a) {
+						m.Addrs = append(m.Addrs, a)
+					}
+				}
+				continue
+			}
+
+			other.Addrs = slices.DeleteFunc(other.Addrs, func(a string) bool { return a == addr })
+			if len(other.Addrs) == 0 {
+				c.remove(other)
+			}
+		}
+
+		if !m.Has(addr) {
+			m.Addrs = append(m.Addrs, addr)
+		}
+	}
+}
+
+// find returns the member known by the given address. The caller must
+// hold the mutex.
+func (c *Cluster) find(addr string) *member {
+	for _, m := range c.members {
+		if m.Has(addr) {
+			return m
+		}
+	}
+	return nil
+}
+
+// findID returns the member with the given node ID. The caller must hold
+// the mutex.
+func (c *Cluster) findID(id string) *member {
+	for _, m := range c.members {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// remove drops a member. The caller must hold the mutex.
+func (c *Cluster) remove(m *member) {
+	c.members = slices.DeleteFunc(c.members, func(other *member) bool { return other == m })
+}
+
+// forget drops the members known only by addr. The caller must hold the
+// mutex.
+func (c *Cluster) forget(addr string) {
+	c.members = slices.DeleteFunc(c.members, func(m *member) bool { return m.ID == "" && m.Has(addr) })
+}
+
+// key identifies the member an update is about in the gossip queue and
+// the tombstones
+func key(u message.Update) string {
+	if u.ID != "" {
+		return u.ID
+	}
+	return u.Addr
+}
+
+// addresses returns an address together with its IP:port form, which is
+// what incoming datagrams carry when it is a hostname
+func addresses(addr string) []string {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil || udpAddr.String() == addr {
+		return []string{addr}
+	}
+	return []string{addr, udpAddr.String()}
+}
+
+func stateName(state message.State) string {
+	switch state {
+	case message.Suspect:
+		return "suspect"
+	case message.Dead:
+		return "dead"
+	default:
+		return "alive"
 	}
-	return false
 }
@@ -1,16 +1,25 @@
 package cluster
 
 import (
+	"slices"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/bloom"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/message"
 )
 
+// MaxGossip is large enough to drain the gossip queue in the tests
+const MaxGossip = 64
+
 func TestNew(t *testing.T) {
 	list := []string{"127.0.0.1:1378", "192.168.1.1:1379"}
-	c := New(list)
+	c := New(list, logger.Discard)
 
 	if c == nil {
-		t.Fatal("New() returned nil")
+		t.Fatal("New(, logger.Discard) returned nil")
 	}
 
 	result := c.List()
@@ -21,12 +30,12 @@ func TestNew(t *testing.T) {
 	// Verify it's a copy and not the original
 	list[0] = "modified"
 	if c.List()[0] == "modified" {
-		t.Error("New() should copy the list, not use the original reference")
+		t.Error("New(, logger.Discard) should copy the list, not use the original reference")
 	}
 }
 
 func TestListReturnsACopy(t *testing.T) {
-	c := New([]string{"127.0.0.1:1378"})
+	c := New([]string{"127.0.0.1:1378"}, logger.Discard)
 
 	list1 := c.List()
 	list2 := c.List()
@@ -85,7 +94,7 @@ func TestMerge(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := New(tt.initial)
+			c := New(tt.initial, logger.Discard)
 			c.Merge(tt.host, tt.toMerge)
 
 			if c.Size() != tt.expectedSize {
@@ -96,7 +105,7 @@ func TestMerge(t *testing.T) {
 }
 
 func TestAdd(t *testing.T) {
-	c := New([]string{})
+	c := New([]string{}, logger.Discard)
 
 	c.Add("127.0.0.1:1378")
 	if c.Size() != 1 {
@@ -123,7 +132,7 @@ func TestAdd(t *testing.T) {
 }
 
 func TestRemove(t *testing.T) {
-	c := New([]string{"127.0.0.1:1378", "192.168.1.1:1379"})
+	c := New([]string{"127.0.0.1:1378", "192.168.1.1:1379"}, logger.Discard)
 
 	c.Remove("127.0.0.1:1378")
 	if c.Size() != 1 {
@@ -143,19 +152,325 @@ func TestRemove(t *testing.T) {
 }
 
 func TestSize(t *testing.T) {
-	c := New([]string{})
+	c := New([]string{}, logger.Discard)
 	if c.Size() != 0 {
 		t.Errorf("Size() = %d, want %d", c.Size(), 0)
 	}
 
-	c = New([]string{"127.0.0.1:1378", "192.168.1.1:1379"})
+	c = New([]string{"127.0.0.1:1378", "192.168.1.1:1379"}, logger.Discard)
 	if c.Size() != 2 {
 		t.Errorf("Size() = %d, want %d", c.Size(), 2)
 	}
 }
 
+func TestSuspicionTimeout(t *testing.T) {
+	c := New([]string{"127.0.0.1:1378", "192.168.1.1:1379"}, logger.Discard)
+	c.SetTimeouts(Timeouts{Suspect: 10 * time.Second, Tombstone: 10 * time.Minute})
+
+	c.Suspect("192.168.1.1:1379")
+
+	now := time.Now()
+	if dead := c.Check(now); len(dead) != 0 {
+		t.Fatalf("Check() right after Suspect() = %v, want nothing", dead)
+	}
+
+	dead := c.Check(now.Add(15 * time.Second))
+	if len(dead) != 1 || dead[0] != "192.168.1.1:1379" {
+		t.Fatalf("Check() after the suspicion timeout = %v, want [192.168.1.1:1379]", dead)
+	}
+	if c.Size() != 1 {
+		t.Errorf("Size() = %d, want 1", c.Size())
+	}
+
+	// The tombstone keeps stale gossip from bringing the peer back
+	c.Merge("127.0.0.1:1000", []string{"192.168.1.1:1379"})
+	if c.Size() != 1 {
+		t.Errorf("Merge() re-added a dead peer")
+	}
+
+	// but a higher incarnation does
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "192.168.1.1:1379"}})
+	if c.Size() != 2 {
+		t.Errorf("Apply() with a higher incarnation didn't revive the peer")
+	}
+}
+
+func TestTombstoneExpiry(t *testing.T) {
+	c := New(nil, logger.Discard)
+	c.SetTimeouts(Timeouts{Suspect: time.Second, Tombstone: time.Minute})
+	c.Apply([]message.Update{{State: message.Dead, Incarnation: 3, Addr: "10.0.0.1:1380"}})
+
+	c.Merge("127.0.0.1:1000", []string{"10.0.0.1:1380"})
+	if c.Size() != 0 {
+		t.Fatalf("Merge() added a peer reported dead")
+	}
+
+	c.Check(time.Now().Add(2 * time.Minute))
+	c.Merge("127.0.0.1:1000", []string{"10.0.0.1:1380"})
+	if c.Size() != 1 {
+		t.Errorf("Merge() after the tombstone expired didn't add the peer")
+	}
+}
+
+func TestApplyIncarnations(t *testing.T) {
+	c := New([]string{"10.0.0.1:1380"}, logger.Discard)
+
+	// A suspicion about an older incarnation is ignored
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 5, Addr: "10.0.0.1:1380"}})
+	c.Apply([]message.Update{{State: message.Suspect, Incarnation: 4, Addr: "10.0.0.1:1380"}})
+	if c.Peers()[0].State == message.Suspect {
+		t.Fatal("Apply() accepted a suspicion about an older incarnation")
+	}
+
+	c.Apply([]message.Update{{State: message.Suspect, Incarnation: 5, Addr: "10.0.0.1:1380"}})
+	if c.Peers()[0].State != message.Suspect {
+		t.Fatal("Apply() ignored a suspicion about the current incarnation")
+	}
+
+	// Alive with the same incarnation doesn't clear it, only a refutation does
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 5, Addr: "10.0.0.1:1380"}})
+	if c.Peers()[0].State != message.Suspect {
+		t.Fatal("Apply() cleared a suspicion without a higher incarnation")
+	}
+
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 6, Addr: "10.0.0.1:1380"}})
+	if m := c.Peers()[0]; m.State != message.Alive || m.Incarnation != 6 {
+		t.Errorf("Apply() refutation = %+v, want alive at incarnation 6", m)
+	}
+}
+
+func TestRefute(t *testing.T) {
+	c := New(nil, logger.Discard)
+	c.SetSelf("127.0.0.1:1378", message.Meta{})
+
+	self := c.State()[0]
+	c.Gossip(MaxGossip)
+
+	c.Apply([]message.Update{{State: message.Suspect, Incarnation: self.Incarnation, Addr: "127.0.0.1:1378"}})
+
+	updates := c.Gossip(MaxGossip)
+	if len(updates) != 1 {
+		t.Fatalf("Gossip() = %v, want a single refutation", updates)
+	}
+	if u := updates[0]; u.State != message.Alive || u.Incarnation != self.Incarnation+1 {
+		t.Errorf("refutation = %v, want alive at incarnation %d", u, self.Incarnation+1)
+	}
+	if c.Size() != 0 {
+		t.Errorf("Apply() added ourselves as a member")
+	}
+}
+
+func TestPeerRecords(t *testing.T) {
+	c := New([]string{"10.0.0.1:1380"}, logger.Discard)
+
+	meta := message.Meta{ID: "9f86d0", TCPPort: 33680, Version: 1, Capabilities: message.CapSearch | message.CapContent}
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 2, Addr: "10.0.0.1:1380", Meta: meta}})
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "192.168.1.1:1379"}})
+
+	peer, ok := c.Lookup("10.0.0.1:1380")
+	if !ok {
+		t.Fatal("Lookup() didn't find the peer")
+	}
+	if peer.Meta != meta || peer.Incarnation != 2 {
+		t.Errorf("Lookup() = %+v, want meta %+v at incarnation 2", peer, meta)
+	}
+
+	c.Pinged("10.0.0.1:1380", 3*time.Millisecond, true)
+	if peer, _ := c.Lookup("10.0.0.1:1380"); !peer.Reachable || peer.RTT != 3*time.Millisecond {
+		t.Errorf("Pinged() not recorded, got %+v", peer)
+	}
+
+	searchable := c.Select(func(p Peer) bool { return p.Capabilities&message.CapSearch != 0 })
+	if len(searchable) != 1 || searchable[0].Addr != "10.0.0.1:1380" {
+		t.Errorf("Select() = %v, want the peer that can search", searchable)
+	}
+
+	if _, ok := c.Lookup("172.16.0.1:1378"); ok {
+		t.Error("Lookup() found an unknown peer")
+	}
+}
+
+func TestSetSelfRaisesIncarnation(t *testing.T) {
+	c := New(nil, logger.Discard)
+	c.SetSelf("127.0.0.1:1378", message.Meta{ID: "9f86d0", Version: 1})
+	first := c.Self().Incarnation
+
+	c.SetSelf("127.0.0.1:1378", message.Meta{ID: "9f86d0", Version: 1})
+	if c.Self().Incarnation != first {
+		t.Errorf("SetSelf() with unchanged meta raised the incarnation")
+	}
+
+	c.SetSelf("127.0.0.1:1378", message.Meta{ID: "9f86d0", TCPPort: 33680, Version: 1})
+	self := c.Self()
+	if self.Incarnation != first+1 || self.TCPPort != 33680 {
+		t.Errorf("Self() = %+v, want the new meta at incarnation %d", self, first+1)
+	}
+
+	updates := c.Gossip(MaxGossip)
+	if len(updates) != 1 || updates[0].TCPPort != 33680 {
+		t.Errorf("Gossip() = %v, want the new meta announced", updates)
+	}
+}
+
+func TestGossipRetransmits(t *testing.T) {
+	c := New(nil, logger.Discard)
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1380"}})
+
+	sent := 0
+	for range 100 {
+		sent += len(c.Gossip(MaxGossip))
+	}
+
+	if sent == 0 || sent >= 100 {
+		t.Errorf("update was gossiped %d times, want a bounded positive number", sent)
+	}
+}
+
+func TestNextProbe(t *testing.T) {
+	list := []string{"127.0.0.1:1378", "192.168.1.1:1379", "10.0.0.1:1380"}
+	c := New(list, logger.Discard)
+
+	seen := make(map[string]int)
+	for range 2 * len(list) {
+		addr, ok := c.NextProbe()
+		if !ok {
+			t.Fatal("NextProbe() found no member")
+		}
+		seen[addr]++
+	}
+
+	for _, addr := range list {
+		if seen[addr] != 2 {
+			t.Errorf("%s was probed %d times in two rounds, want 2", addr, seen[addr])
+		}
+	}
+
+	if _, ok := New(nil, logger.Discard).NextProbe(); ok {
+		t.Error("NextProbe() on an empty cluster should find nothing")
+	}
+}
+
+func TestOneMemberPerID(t *testing.T) {
+	c := New(nil, logger.Discard)
+
+	c.Apply([]message.Update{
+		{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}},
+		{State: message.Alive, Incarnation: 2, Addr: "192.168.1.1:1378", Meta: message.Meta{ID: "aa"}},
+	})
+
+	peers := c.Peers()
+	if len(peers) != 1 {
+		t.Fatalf("Peers() = %v, want a single member", peers)
+	}
+	if p := peers[0]; p.Addr != "192.168.1.1:1378" || !p.Has("10.0.0.1:1378") || !p.Has("192.168.1.1:1378") {
+		t.Errorf("member = %+v, want both addresses, advertising the latest", p)
+	}
+	if _, ok := c.Lookup("10.0.0.1:1378"); !ok {
+		t.Errorf("Lookup() by the old address failed")
+	}
+}
+
+func TestSeedAdoption(t *testing.T) {
+	c := New([]string{"10.0.0.1:1378"}, logger.Discard)
+
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}}})
+	if p, ok := c.LookupID("aa"); !ok || p.Addr != "10.0.0.1:1378" || c.Size() != 1 {
+		t.Errorf("seed was not adopted, peers = %v", c.Peers())
+	}
+
+	// The seed was known under an address the node doesn't advertise
+	c = New([]string{"10.0.0.2:1378"}, logger.Discard)
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}}})
+	c.Identify("10.0.0.2:1378", "aa")
+	if p, ok := c.LookupID("aa"); !ok || !p.Has("10.0.0.2:1378") || c.Size() != 1 {
+		t.Errorf("Identify() didn't merge the seed, peers = %v", c.Peers())
+	}
+}
+
+func TestIdentifySelf(t *testing.T) {
+	c := New([]string{"localhost:1378", "10.0.0.1:1378"}, logger.Discard)
+	c.SetSelf("127.0.0.1:1378", message.Meta{ID: "ff"})
+
+	c.Identify("localhost:1378", "ff")
+
+	if list := c.List(); len(list) != 1 || list[0] != "10.0.0.1:1378" {
+		t.Errorf("List() = %v, want our own alias dropped", list)
+	}
+
+	// Others gossiping the alias don't bring it back
+	c.Apply([]message.Update{{State: message.Alive, Addr: "localhost:1378"}})
+	if c.Size() != 1 {
+		t.Errorf("Apply() added our own alias, list = %v", c.List())
+	}
+}
+
+func TestTombstoneByID(t *testing.T) {
+	c := New(nil, logger.Discard)
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}}})
+	c.Apply([]message.Update{{State: message.Dead, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}}})
+
+	// Stale gossip under another address doesn't bring the node back
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "10.0.0.2:1378", Meta: message.Meta{ID: "aa"}}})
+	if c.Size() != 0 {
+		t.Errorf("stale update resurrected a dead node, peers = %v", c.Peers())
+	}
+
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 2, Addr: "10.0.0.2:1378", Meta: message.Meta{ID: "aa"}}})
+	if p, ok := c.LookupID("aa"); !ok || p.Addr != "10.0.0.2:1378" {
+		t.Errorf("restarted node was not accepted, peers = %v", c.Peers())
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	c := New([]string{"10.0.0.1:1378", "10.0.0.2:1378"}, logger.Discard)
+	c.Apply([]message.Update{{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}}})
+
+	summary := bloom.New(1, 0.01, 1024)
+	summary.Add("movie.mkv")
+	c.Summarize("", "aa", summary)
+	c.Summarize("10.0.0.2:1378", "", summary)
+
+	for _, p := range c.Peers() {
+		if !p.MayHave("movie.mkv") || p.MayHave("song.mp3") {
+			t.Errorf("peer %s has summary %v, want the one sent", p.Addr, p.Summary)
+		}
+	}
+
+	c.Add("10.0.0.3:1378")
+	if p, ok := c.Lookup("10.0.0.3:1378"); !ok || !p.MayHave("song.mp3") {
+		t.Errorf("peer without a summary may not have a file")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	c := New(nil, logger.Discard)
+
+	var states []message.State
+	c.SetNotify(func(p Peer) { states = append(states, p.State) })
+
+	update := message.Update{State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378", Meta: message.Meta{ID: "aa"}}
+	c.Apply([]message.Update{update})
+	// Hearing the same again changes nothing
+	c.Apply([]message.Update{update})
+
+	update.State = message.Suspect
+	c.Apply([]message.Update{update})
+
+	update.State = message.Alive
+	update.Incarnation = 2
+	c.Apply([]message.Update{update})
+
+	update.State = message.Dead
+	c.Apply([]message.Update{update})
+
+	want := []message.State{message.Alive, message.Suspect, message.Alive, message.Dead}
+	if !slices.Equal(states, want) {
+		t.Errorf("notified of %v, want %v", states, want)
+	}
+}
+
 func TestConcurrentAccess(t *testing.T) {
-	c := New([]string{"127.0.0.1:1378"})
+	c := New([]string{"127.0.0.1:1378"}, logger.Discard)
 
 	var wg sync.WaitGroup
 	iterations := 100
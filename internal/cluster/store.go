@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// record is a peer as kept in the state file
+type record struct {
+	Addr         string             `json:"addr"`
+	Addrs        []string           `json:"addrs"`
+	ID           string             `json:"id,omitempty"`
+	TCPPort      int                `json:"tcp_port,omitempty"`
+	Version      int                `json:"version,omitempty"`
+	Capabilities message.Capability `json:"capabilities,omitempty"`
+	LastSeen     time.Time          `json:"last_seen"`
+	Successes    int                `json:"successes"`
+	Failures     int                `json:"failures"`
+}
+
+func newRecord(p Peer) record {
+	return record{
+		Addr:         p.Addr,
+		Addrs:        slices.Clone(p.Addrs),
+		ID:           p.ID,
+		TCPPort:      p.TCPPort,
+		Version:      p.Version,
+		Capabilities: p.Capabilities,
+		LastSeen:     p.LastSeen,
+		Successes:    p.Successes,
+		Failures:     p.Failures,
+	}
+}
+
+func (r record) key() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Addr
+}
+
+// Load restores the peers stored in the state file at path, so the node
+// rejoins the cluster even when its initial members are gone. Peers not
+// seen for maxAge are forgotten. A missing file is not an error.
+func (c *Cluster) Load(path string, maxAge time.Duration) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read peers: %w", err)
+	}
+
+	var records []record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return 0, fmt.Errorf("failed to parse peers in %s: %w", path, err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	restored := 0
+	for _, r := range records {
+		if r.Addr == "" || now.Sub(r.LastSeen) > maxAge {
+			continue
+		}
+		if len(r.Addrs) == 0 {
+			r.Addrs = []string{r.Addr}
+		}
+		c.known[r.key()] = r
+
+		if c.restore(r, now) {
+			restored++
+		}
+	}
+
+	return restored, nil
+}
+
+// restore adds a stored peer as a member, merging it with the initial
+// member it may already be known as. The caller must hold the mutex.
+func (c *Cluster) restore(r record, now time.Time) bool {
+	if r.ID != "" && r.ID == c.meta.ID {
+		return false
+	}
+
+	m := c.match(message.Update{Addr: r.Addr, Meta: message.Meta{ID: r.ID}})
+	if m == nil && r.ID != "" {
+		for _, addr := range r.Addrs {
+			if other := c.find(addr); other != nil && other.ID == "" {
+				m = other
+				m.ID = r.ID
+				break
+			}
+		}
+	}
+	if m == nil {
+		if r.ID == "" && slices.ContainsFunc(r.Addrs, func(addr string) bool { return c.find(addr) != nil }) {
+			return false
+		}
+		m = newMember(r.Addr, nil, 0, now)
+		c.members = append(c.members, m)
+	}
+
+	m.Addr = r.Addr
+	m.Meta = message.Meta{ID: r.ID, TCPPort: r.TCPPort, Version: r.Version, Capabilities: r.Capabilities}
+	m.LastSeen = r.LastSeen
+	m.Successes += r.Successes
+	m.Failures += r.Failures
+	c.claim(m, r.Addrs)
+	return true
+}
+
+// Save writes the peer table to the state file at path: the members, the
+// dead members and the peers stored before that weren't seen since, minus
+// those not seen for maxAge
+func (c *Cluster) Save(path string, maxAge time.Duration) error {
+	c.mutex.Lock()
+
+	now := time.Now()
+	for _, m := range slices.Concat(c.members, c.deadMembers()) {
+		r := newRecord(m.copy())
+		// A peer stored before its ID was known is stored by address
+		for _, addr := range r.Addrs {
+			if old, ok := c.known[addr]; ok && old.ID == "" {
+				delete(c.known, addr)
+			}
+		}
+		c.known[r.key()] = r
+	}
+
+	records := make([]record, 0, len(c.known))
+	for k, r := range c.known {
+		if now.Sub(r.LastSeen) > maxAge {
+			delete(c.known, k)
+			continue
+		}
+		records = append(records, r)
+	}
+
+	c.mutex.Unlock()
+
+	slices.SortFunc(records, func(a, b record) int { return b.LastSeen.Compare(a.LastSeen) })
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode peers: %w", err)
+	}
+
+	// Write a temporary file and rename it over the old one, so a crash
+	// never leaves a truncated state file behind
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write peers: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write peers: %w", err)
+	}
+
+	return nil
+}
+
+// deadMembers returns the tombstones. The caller must hold the mutex.
+func (c *Cluster) deadMembers() []*member {
+	dead := make([]*member, 0, len(c.dead))
+	for _, m := range c.dead {
+		dead = append(dead, m)
+	}
+	return dead
+}
@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+const week = 7 * 24 * time.Hour
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".p2p", "peers.json")
+
+	c := New(nil, logger.Discard)
+	c.Apply([]message.Update{{
+		State: message.Alive, Incarnation: 1, Addr: "10.0.0.1:1378",
+		Meta: message.Meta{ID: "aa", TCPPort: 33680, Version: 1, Capabilities: message.CapSearch},
+	}})
+	c.Pinged("10.0.0.1:1378", time.Millisecond, true)
+	c.Pinged("10.0.0.1:1378", 0, false)
+
+	if err := c.Save(path, week); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// The peer is restored into the initial member it is known as
+	restored := New([]string{"10.0.0.1:1378"}, logger.Discard)
+	n, err := restored.Load(path, week)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if n != 1 || restored.Size() != 1 {
+		t.Fatalf("Load() = %d, peers = %v, want a single peer", n, restored.Peers())
+	}
+
+	p, ok := restored.LookupID("aa")
+	if !ok {
+		t.Fatalf("LookupID() found nothing, peers = %v", restored.Peers())
+	}
+	if p.TCPPort != 33680 || p.Version != 1 || p.Capabilities != message.CapSearch {
+		t.Errorf("restored meta = %+v", p.Meta)
+	}
+	if p.Successes != 1 || p.Failures != 1 {
+		t.Errorf("restored stats = %d/%d, want 1/1", p.Successes, p.Failures)
+	}
+}
+
+func TestLoadAgesOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	data := `[
+		{"addr": "10.0.0.1:1378", "id": "aa", "last_seen": "` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"},
+		{"addr": "10.0.0.2:1378", "id": "bb", "last_seen": "` + time.Now().Add(-2*week).Format(time.RFC3339) + `"}
+	]`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(nil, logger.Discard)
+	if n, err := c.Load(path, week); err != nil || n != 1 {
+		t.Fatalf("Load() = %d, %v, want 1 peer", n, err)
+	}
+	if list := c.List(); len(list) != 1 || list[0] != "10.0.0.1:1378" {
+		t.Errorf("List() = %v, want the stale peer left out", list)
+	}
+}
+
+func TestSaveKeepsGonePeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	c := New([]string{"10.0.0.1:1378"}, logger.Discard)
+	if err := c.Save(path, week); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// A peer that left is still worth trying on the next start
+	c.Remove("10.0.0.1:1378")
+	if err := c.Save(path, week); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	restored := New(nil, logger.Discard)
+	if n, err := restored.Load(path, week); err != nil || n != 1 {
+		t.Errorf("Load() = %d, %v, want the peer that left", n, err)
+	}
+
+	// Until it hasn't been seen for too long
+	if err := c.Save(path, 0); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if n, err := New(nil, logger.Discard).Load(path, week); err != nil || n != 0 {
+		t.Errorf("Load() = %d, %v, want no peers", n, err)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	c := New(nil, logger.Discard)
+	if n, err := c.Load(filepath.Join(t.TempDir(), "peers.json"), week); err != nil || n != 0 {
+		t.Errorf("Load() = %d, %v, want nothing and no error", n, err)
+	}
+}
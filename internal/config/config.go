@@ -13,6 +13,20 @@ type Config struct {
 	Port            int    `mapstructure:"port"`
 	DiscoveryPeriod int    `mapstructure:"period"`
 	WaitingTime     int    `mapstructure:"waiting"`
+	CollectTime     int    `mapstructure:"collect"`
+	SearchTTL       int    `mapstructure:"ttl"`
+	ResponseDelay   int    `mapstructure:"delay"`
+	ProbePeriod     int    `mapstructure:"probe"`
+	SuspectTime     int    `mapstructure:"suspect"`
+	TombstoneTime   int    `mapstructure:"tombstone"`
+	Beacon          string `mapstructure:"beacon"`
+	BeaconPort      int    `mapstructure:"beacon_port"`
+	BeaconPeriod    int    `mapstructure:"beacon_period"`
+	MDNS            bool   `mapstructure:"mdns"`
+	PersistPeriod   int    `mapstructure:"persist"`
+	StaleTime       int    `mapstructure:"stale"`
+	Log             string `mapstructure:"log"`
+	LogLevel        string `mapstructure:"log_level"`
 }
 
 func Read() Config {
@@ -41,3 +55,21 @@ func Read() Config {
 
 	return cfg
 }
+
+// Defaults returns the default configuration, without looking at config
+// files or the environment
+func Defaults() Config {
+	v := viper.New()
+	v.SetConfigType("yml")
+
+	if err := v.ReadConfig(bytes.NewBufferString(Default)); err != nil {
+		log.Fatalf("err: %s", err)
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		log.Fatalf("err: %s", err)
+	}
+
+	return cfg
+}
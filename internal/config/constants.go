@@ -13,25 +13,165 @@ const (
 	// FileNameLength is the fixed length for file name in protocol
 	FileNameLength = 64
 
-	// FileSizeLength is the fixed length for file size in protocol
-	FileSizeLength = 10
+	// ChunkSize is the size of the byte ranges a swarming download fetches
+	// from different peers
+	ChunkSize = 1 << 20
+
+	// FileSizeLength is the fixed length for file size, offset and length
+	// fields in protocol, wide enough for multi-GB files
+	FileSizeLength = 20
+
+	// FileHashLength is the fixed length for the hex encoded SHA-256 of the
+	// file content in protocol
+	FileHashLength = 64
+
+	// EventQueueSize is how many events of an embedded node may wait for
+	// its handler, membership changes beyond it are dropped
+	EventQueueSize = 256
 )
 
 // Protocol constants
 const (
+	// ProtocolVersion is advertised to the other members of the cluster
+	ProtocolVersion = 1
+
 	// TransferMethodTCP indicates TCP-based file transfer
 	TransferMethodTCP = 1
 )
 
+// Search constants
+const (
+	// MaxFileReplies bounds the number of File replies buffered per search
+	MaxFileReplies = 64
+
+	// MessageHeaderSize is the space reserved for the fixed fields of a
+	// Result or Catalog message when packing matches into a datagram
+	MessageHeaderSize = 128
+)
+
 // Timing constants
 const (
-	// NonPriorResponseDelay is the delay for non-priority responders
-	NonPriorResponseDelay = 10 * time.Second
+	// ScoreHalfLife is how long it takes for what a peer did to count half
+	// as much towards its score
+	ScoreHalfLife = time.Hour
+
+	// ListPageTimeout is how long to wait for a page of a peer's catalog
+	ListPageTimeout = 3 * time.Second
+
+	// PingTimeout is how long to wait for a Pong before a peer counts as
+	// unreachable
+	PingTimeout = 2 * time.Second
+
+	// ListPageRetries is how many times a lost catalog page is requested again
+	ListPageRetries = 2
+)
+
+// Membership constants
+const (
+	// ProbeTimeout is how long a probe waits for a direct Pong before
+	// asking other members to ping the target
+	ProbeTimeout = 500 * time.Millisecond
+
+	// IndirectProbeTimeout is how long a probe waits for a relayed Pong
+	// after sending the PingReq messages
+	IndirectProbeTimeout = time.Second
+
+	// IndirectProbes is how many members are asked to ping a target that
+	// didn't answer a direct probe
+	IndirectProbes = 3
+
+	// MaxPiggyback bounds the membership updates carried by one message
+	MaxPiggyback = 8
+
+	// GossipRetransmits is multiplied by log10 of the cluster size to get
+	// how many times an update is piggybacked before it is dropped
+	GossipRetransmits = 4
+
+	// BeaconGroup is the multicast group LAN beacons are sent to
+	BeaconGroup = "239.255.13.78"
+
+	// MDNSQueryPeriod is how often the network is browsed for other nodes
+	// over mDNS
+	MDNSQueryPeriod = 30 * time.Second
+)
+
+// File summary constants
+const (
+	// SummaryFalsePositive is the false positive rate file summaries are
+	// sized for
+	SummaryFalsePositive = 0.01
+
+	// SummaryMaxBits bounds the size of a file summary so it fits in a
+	// datagram, a node sharing more files gets more false positives
+	SummaryMaxBits = 8192
+)
+
+// Flooding constants
+const (
+	// MaxSearchTTL bounds the hops a forwarded file request travels,
+	// whatever TTL its originator set
+	MaxSearchTTL = 7
+
+	// DownloadSearches is how many times the peers that have a file are
+	// searched for when downloading it from them keeps failing
+	DownloadSearches = 2
+
+	// StageWait is how long, on top of the response delay, a file request
+	// sent to the DHT providers or the members whose summary matches waits
+	// for one of them to answer before it is sent further, in case the
+	// records or summaries are stale
+	StageWait = 3 * time.Second
+
+	// RouteTimeout is how long a node remembers a file request it has
+	// seen, to drop it when it comes around again and to relay the replies
+	// back to where it came from
+	RouteTimeout = 5 * time.Minute
+)
+
+// DHT constants
+const (
+	// DHTBucketSize is k, the size of a routing table bucket and the number
+	// of nodes a provider record is stored at
+	DHTBucketSize = 8
+
+	// DHTConcurrency is alpha, the number of queries a lookup has in flight
+	DHTConcurrency = 3
+
+	// DHTQueryTimeout is how long a lookup waits for a node to answer
+	DHTQueryTimeout = time.Second
+
+	// DHTProviderTTL is how long a provider record is kept, the provider
+	// republishes well before it expires
+	DHTProviderTTL = 30 * time.Minute
+
+	// DHTPublishDelay is how long after start a node first publishes its
+	// provider records, giving it time to join the cluster
+	DHTPublishDelay = 5 * time.Second
+
+	// DHTRepublishPeriod is how often a node publishes provider records for
+	// its files
+	DHTRepublishPeriod = 10 * time.Minute
+
+	// DHTMaxProviders bounds the providers returned in a Found message
+	DHTMaxProviders = 16
 )
 
 // Message type constants
 const (
-	MsgDiscover = "DISCOVER"
-	MsgGet      = "Get"
-	MsgFile     = "File"
+	MsgGet     = "Get"
+	MsgGetHash = "GetHash"
+	MsgFile    = "File"
+	MsgSearch  = "Search"
+	MsgResult  = "Result"
+	MsgList    = "List"
+	MsgCatalog = "Catalog"
+	MsgPing    = "Ping"
+	MsgPong    = "Pong"
+	MsgPingReq = "PingReq"
+	MsgSync    = "Sync"
+	MsgBeacon  = "Beacon"
+	MsgLookup  = "Lookup"
+	MsgFound   = "Found"
+	MsgProvide = "Provide"
+	MsgSummary = "Summary"
 )
@@ -5,4 +5,18 @@ host: 127.0.0.1
 port: 1378
 period: 20
 waiting: 100
+collect: 3
+ttl: 1
+delay: 10
+probe: 2
+suspect: 10
+tombstone: 600
+beacon: ""
+beacon_port: 1377
+beacon_period: 5
+mdns: false
+persist: 60
+stale: 604800
+log: pterm
+log_level: info
 `
@@ -0,0 +1,172 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+// Size is the length of a key in bytes, the same as a node ID's
+const Size = 20
+
+var ErrInvalidKey = errors.New("invalid DHT key")
+
+// Key identifies both nodes and content in the DHT. The distance between
+// two keys is their XOR.
+type Key [Size]byte
+
+// ParseKey decodes a hex encoded key, like a node ID
+func ParseKey(s string) (Key, error) {
+	var k Key
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != Size {
+		return k, fmt.Errorf("%w: %q", ErrInvalidKey, s)
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// NameKey returns the key provider records for a file name are kept under
+func NameKey(name string) Key {
+	return keyOf("name:" + name)
+}
+
+// ContentKey returns the key provider records for a content hash are kept
+// under
+func ContentKey(hash string) Key {
+	return keyOf("hash:" + hash)
+}
+
+func keyOf(s string) Key {
+	var k Key
+	sum := sha256.Sum256([]byte(s))
+	copy(k[:], sum[:Size])
+	return k
+}
+
+// String returns the hex encoding of the key
+func (k Key) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// Distance returns the XOR distance between two keys
+func (k Key) Distance(other Key) Key {
+	var d Key
+	for i := range k {
+		d[i] = k[i] ^ other[i]
+	}
+	return d
+}
+
+// Closer reports whether a is closer to k than b
+func (k Key) Closer(a, b Key) bool {
+	da, db := k.Distance(a), k.Distance(b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// bucket returns the index of the k-bucket other falls in, the number of
+// leading bits it shares with k, or -1 when they are equal
+func (k Key) bucket(other Key) int {
+	d := k.Distance(other)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return -1
+}
+
+// Contact is a node in the DHT
+type Contact struct {
+	ID   Key
+	Addr string
+}
+
+// Table is the Kademlia routing table: for every distance range a k-bucket
+// of at most k contacts, least recently seen first
+type Table struct {
+	self    Key
+	k       int
+	buckets [Size * 8][]Contact
+	mutex   sync.RWMutex
+}
+
+func NewTable(self Key, k int) *Table {
+	return &Table{self: self, k: k}
+}
+
+// Self returns our own key
+func (t *Table) Self() Key {
+	return t.self
+}
+
+// Add records that a contact was seen. It moves to the tail of its bucket,
+// or is left out when the bucket is full of other contacts, which Kademlia
+// prefers since long lived nodes tend to stay.
+func (t *Table) Add(c Contact) {
+	i := t.self.bucket(c.ID)
+	if i < 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	bucket := slices.DeleteFunc(t.buckets[i], func(other Contact) bool { return other.ID == c.ID })
+	if len(bucket) < t.k {
+		bucket = append(bucket, c)
+	}
+	t.buckets[i] = bucket
+}
+
+// Remove drops a contact, making room in its bucket
+func (t *Table) Remove(id Key) {
+	i := t.self.bucket(id)
+	if i < 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.buckets[i] = slices.DeleteFunc(t.buckets[i], func(other Contact) bool { return other.ID == id })
+}
+
+// Contacts returns every contact in the table
+func (t *Table) Contacts() []Contact {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var contacts []Contact
+	for _, bucket := range t.buckets {
+		contacts = append(contacts, bucket...)
+	}
+	return contacts
+}
+
+// Closest returns up to n contacts closest to target
+func (t *Table) Closest(target Key, n int) []Contact {
+	contacts := t.Contacts()
+	sortByDistance(target, contacts)
+	if len(contacts) > n {
+		contacts = contacts[:n]
+	}
+	return contacts
+}
+
+// Len returns the number of contacts in the table
+func (t *Table) Len() int {
+	return len(t.Contacts())
+}
+
+func sortByDistance(target Key, contacts []Contact) {
+	slices.SortFunc(contacts, func(a, b Contact) int {
+		da, db := target.Distance(a.ID), target.Distance(b.ID)
+		return bytes.Compare(da[:], db[:])
+	})
+}
@@ -0,0 +1,115 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func key(b ...byte) Key {
+	var k Key
+	copy(k[:], b)
+	return k
+}
+
+func TestParseKey(t *testing.T) {
+	k := NameKey("movie.mkv")
+
+	parsed, err := ParseKey(k.String())
+	if err != nil || parsed != k {
+		t.Errorf("ParseKey(%q) = %v, %v, want %v", k.String(), parsed, err, k)
+	}
+
+	for _, s := range []string{"", "zz", "00ff"} {
+		if _, err := ParseKey(s); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ParseKey(%q) error = %v, want ErrInvalidKey", s, err)
+		}
+	}
+
+	if NameKey("a") == ContentKey("a") {
+		t.Errorf("name and content keys of the same string collide")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	self := key(0x00)
+
+	tests := []struct {
+		other Key
+		want  int
+	}{
+		{other: key(0x80), want: 0},
+		{other: key(0x01), want: 7},
+		{other: key(0x00, 0x40), want: 9},
+		{other: self, want: -1},
+	}
+
+	for _, tt := range tests {
+		if got := self.bucket(tt.other); got != tt.want {
+			t.Errorf("bucket(%s) = %d, want %d", tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestTableFullBucket(t *testing.T) {
+	table := NewTable(key(0x00), 2)
+
+	// All in the first bucket, the oldest ones are kept
+	table.Add(Contact{ID: key(0x80), Addr: "a"})
+	table.Add(Contact{ID: key(0x81), Addr: "b"})
+	table.Add(Contact{ID: key(0x82), Addr: "c"})
+	if table.Len() != 2 {
+		t.Fatalf("Len() = %d, want a full bucket of 2", table.Len())
+	}
+
+	table.Remove(key(0x80))
+	table.Add(Contact{ID: key(0x82), Addr: "c"})
+	if closest := table.Closest(key(0x82), 1); len(closest) != 1 || closest[0].Addr != "c" {
+		t.Errorf("Closest() = %v, want the contact that took the free slot", closest)
+	}
+
+	table.Add(Contact{ID: key(0x00), Addr: "self"})
+	if table.Len() != 2 {
+		t.Errorf("Add() accepted ourselves")
+	}
+}
+
+func TestClosest(t *testing.T) {
+	table := NewTable(key(0x00), 8)
+	for i := 1; i <= 5; i++ {
+		table.Add(Contact{ID: key(byte(i << 4)), Addr: fmt.Sprint(i)})
+	}
+
+	closest := table.Closest(key(0x30), 3)
+	want := []string{"3", "2", "1"}
+	for i, c := range closest {
+		if c.Addr != want[i] {
+			t.Fatalf("Closest() = %v, want addresses %v", closest, want)
+		}
+	}
+}
+
+func TestProviders(t *testing.T) {
+	p := NewProviders()
+	now := time.Now()
+	k := ContentKey("abc")
+
+	p.Add(k, "10.0.0.1:1378", now.Add(time.Minute))
+	p.Add(k, "10.0.0.2:1378", now.Add(time.Hour))
+
+	if addrs := p.Get(k, now, 10); len(addrs) != 2 {
+		t.Errorf("Get() = %v, want both providers", addrs)
+	}
+	if addrs := p.Get(k, now, 1); len(addrs) != 1 {
+		t.Errorf("Get() = %v, want the limit of 1", addrs)
+	}
+	if addrs := p.Get(k, now.Add(2*time.Minute), 10); len(addrs) != 1 || addrs[0] != "10.0.0.2:1378" {
+		t.Errorf("Get() = %v, want the expired record gone", addrs)
+	}
+
+	p.Expire(now.Add(2 * time.Hour))
+	if p.Len() != 0 {
+		t.Errorf("Len() = %d after expiry, want 0", p.Len())
+	}
+}
@@ -0,0 +1,132 @@
+package dht
+
+import (
+	"context"
+	"slices"
+)
+
+// Reply is what a node answers a lookup query with: the providers it
+// stores for the key and the contacts it knows closest to it
+type Reply struct {
+	Contacts  []Contact
+	Providers []string
+}
+
+// Query sends a lookup query for a key to a contact
+type Query func(ctx context.Context, c Contact) (Reply, error)
+
+// Result is the outcome of an iterative lookup
+type Result struct {
+	Providers []string
+	// Closest are the contacts closest to the key that answered, at most k
+	Closest []Contact
+	// Queries is the number of queries sent
+	Queries int
+}
+
+type candidate struct {
+	Contact
+	queried  bool
+	answered bool
+}
+
+// Lookup runs an iterative Kademlia lookup for target. It starts from the
+// k contacts in the table closest to target and queries alpha of them at a
+// time, learning closer contacts from every answer, until the k closest
+// contacts it knows of have all been queried. With findProviders set it
+// stops as soon as a round turns up providers.
+func Lookup(ctx context.Context, table *Table, target Key, k, alpha int, findProviders bool, query Query) Result {
+	var result Result
+	candidates := make(map[Key]*candidate)
+	var order []*candidate
+
+	add := func(contacts []Contact) {
+		for _, c := range contacts {
+			if c.ID == table.Self() || candidates[c.ID] != nil {
+				continue
+			}
+			cand := &candidate{Contact: c}
+			candidates[c.ID] = cand
+			order = append(order, cand)
+		}
+		slices.SortFunc(order, func(a, b *candidate) int {
+			if target.Closer(a.ID, b.ID) {
+				return -1
+			}
+			if target.Closer(b.ID, a.ID) {
+				return 1
+			}
+			return 0
+		})
+	}
+	add(table.Closest(target, k))
+
+	type answer struct {
+		cand  *candidate
+		reply Reply
+		err   error
+	}
+
+	seen := make(map[string]bool)
+	for ctx.Err() == nil {
+		// The next round queries the closest contacts not queried yet among
+		// the k closest that haven't failed
+		var round []*candidate
+		live := 0
+		for _, cand := range order {
+			if live == k || len(round) == alpha {
+				break
+			}
+			if cand.queried && !cand.answered {
+				continue
+			}
+			live++
+			if !cand.queried {
+				round = append(round, cand)
+			}
+		}
+		if len(round) == 0 {
+			break
+		}
+
+		answers := make(chan answer, len(round))
+		for _, cand := range round {
+			cand.queried = true
+			result.Queries++
+			go func() {
+				reply, err := query(ctx, cand.Contact)
+				answers <- answer{cand: cand, reply: reply, err: err}
+			}()
+		}
+
+		for range round {
+			a := <-answers
+			if a.err != nil {
+				continue
+			}
+			a.cand.answered = true
+			add(a.reply.Contacts)
+			for _, addr := range a.reply.Providers {
+				if !seen[addr] {
+					seen[addr] = true
+					result.Providers = append(result.Providers, addr)
+				}
+			}
+		}
+
+		if findProviders && len(result.Providers) > 0 {
+			break
+		}
+	}
+
+	for _, cand := range order {
+		if len(result.Closest) == k {
+			break
+		}
+		if cand.answered {
+			result.Closest = append(result.Closest, cand.Contact)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,152 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// network is an in memory DHT: every node has a routing table and a
+// provider store, and queries are answered by calling the node directly
+type network struct {
+	nodes map[Key]*node
+}
+
+type node struct {
+	table     *Table
+	providers *Providers
+	down      bool
+}
+
+const (
+	k     = 8
+	alpha = 3
+)
+
+func newNetwork(t *testing.T, size int) *network {
+	t.Helper()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	n := &network{nodes: make(map[Key]*node)}
+	ids := make([]Key, 0, size)
+	for range size {
+		var id Key
+		for i := range id {
+			id[i] = byte(rng.UintN(256))
+		}
+		ids = append(ids, id)
+		n.nodes[id] = &node{table: NewTable(id, k), providers: NewProviders()}
+	}
+
+	// Every node knows a handful of random others and, as it would after
+	// looking itself up on joining, its closest neighbors
+	for _, id := range ids {
+		for range 20 {
+			other := ids[rng.IntN(len(ids))]
+			n.nodes[id].table.Add(Contact{ID: other, Addr: other.String()})
+		}
+
+		neighbors := make([]Contact, 0, len(ids))
+		for _, other := range ids {
+			neighbors = append(neighbors, Contact{ID: other, Addr: other.String()})
+		}
+		sortByDistance(id, neighbors)
+		for _, c := range neighbors[:k+1] {
+			n.nodes[id].table.Add(c)
+		}
+	}
+	return n
+}
+
+func (n *network) query(target Key) Query {
+	return func(_ context.Context, c Contact) (Reply, error) {
+		peer := n.nodes[c.ID]
+		if peer.down {
+			return Reply{}, errors.New("timeout")
+		}
+		return Reply{
+			Contacts:  peer.table.Closest(target, k),
+			Providers: peer.providers.Get(target, time.Now(), 16),
+		}, nil
+	}
+}
+
+func (n *network) any() *node {
+	for _, node := range n.nodes {
+		return node
+	}
+	return nil
+}
+
+func TestLookupFindsClosest(t *testing.T) {
+	net := newNetwork(t, 500)
+	target := NameKey("movie.mkv")
+
+	start := net.any()
+	result := Lookup(context.Background(), start.table, target, k, alpha, false, net.query(target))
+
+	// The true closest nodes, by brute force
+	all := make([]Contact, 0, len(net.nodes))
+	for id := range net.nodes {
+		if id != start.table.Self() {
+			all = append(all, Contact{ID: id})
+		}
+	}
+	sortByDistance(target, all)
+
+	if len(result.Closest) != k {
+		t.Fatalf("Lookup() found %d contacts, want %d", len(result.Closest), k)
+	}
+	for i, c := range result.Closest {
+		if c.ID != all[i].ID {
+			t.Errorf("Closest[%d] = %s, want %s", i, c.ID, all[i].ID)
+		}
+	}
+	if result.Queries > 100 {
+		t.Errorf("Lookup() sent %d queries in a network of %d, want far fewer", result.Queries, len(net.nodes))
+	}
+}
+
+func TestLookupProviders(t *testing.T) {
+	net := newNetwork(t, 500)
+	target := ContentKey("abc")
+
+	// Publish at the k closest nodes, as found by a lookup
+	publisher := net.any()
+	closest := Lookup(context.Background(), publisher.table, target, k, alpha, false, net.query(target)).Closest
+	for _, c := range closest {
+		net.nodes[c.ID].providers.Add(target, "publisher:1378", time.Now().Add(time.Hour))
+	}
+
+	// Some of them may be gone by the time someone looks
+	for _, c := range closest[:k/2] {
+		net.nodes[c.ID].down = true
+	}
+
+	for id, requester := range net.nodes {
+		if requester.down {
+			continue
+		}
+		result := Lookup(context.Background(), requester.table, target, k, alpha, true, net.query(target))
+		if len(result.Providers) != 1 || result.Providers[0] != "publisher:1378" {
+			t.Fatalf("Lookup() from %s = %v, want the publisher", id, result.Providers)
+		}
+		if result.Queries > 60 {
+			t.Errorf("Lookup() from %s sent %d queries, want far fewer", id, result.Queries)
+		}
+	}
+}
+
+func TestLookupEmptyTable(t *testing.T) {
+	table := NewTable(NameKey("self"), k)
+	query := func(context.Context, Contact) (Reply, error) {
+		return Reply{}, fmt.Errorf("unexpected query")
+	}
+
+	if result := Lookup(context.Background(), table, NameKey("x"), k, alpha, true, query); result.Queries != 0 {
+		t.Errorf("Lookup() = %+v, want no queries", result)
+	}
+}
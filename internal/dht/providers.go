@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// Providers stores the provider records this node is responsible for: for
+// every key the addresses of the nodes that hold the content, until their
+// records expire
+type Providers struct {
+	records map[Key]map[string]time.Time
+	mutex   sync.Mutex
+}
+
+func NewProviders() *Providers {
+	return &Providers{records: make(map[Key]map[string]time.Time)}
+}
+
+// Add records that addr provides key until expires
+func (p *Providers) Add(key Key, addr string, expires time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.records[key] == nil {
+		p.records[key] = make(map[string]time.Time)
+	}
+	p.records[key][addr] = expires
+}
+
+// Get returns up to limit providers of key that haven't expired at now
+func (p *Providers) Get(key Key, now time.Time, limit int) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var addrs []string
+	for addr, expires := range p.records[key] {
+		if !now.Before(expires) {
+			delete(p.records[key], addr)
+			continue
+		}
+		if len(addrs) < limit {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(p.records[key]) == 0 {
+		delete(p.records, key)
+	}
+	return addrs
+}
+
+// Expire drops the records that expired at now
+func (p *Providers) Expire(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, addrs := range p.records {
+		for addr, expires := range addrs {
+			if !now.Before(expires) {
+				delete(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			delete(p.records, key)
+		}
+	}
+}
+
+// Len returns the number of keys with providers
+func (p *Providers) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.records)
+}
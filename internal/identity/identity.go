@@ -0,0 +1,64 @@
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Dir holds the node's own state inside the shared folder, it is never
+	// shared
+	Dir = ".p2p"
+
+	// Size is the length of a node ID in bytes
+	Size = 20
+)
+
+var ErrInvalidID = errors.New("invalid node ID")
+
+// Load returns the ID of the node sharing folder, generating and storing a
+// new one on first use, so the node keeps its identity across restarts and
+// address changes
+func Load(folder string) (string, error) {
+	path := filepath.Join(folder, Dir, "id")
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if !Valid(id) {
+			return "", fmt.Errorf("%w in %s: %q", ErrInvalidID, path, id)
+		}
+		return id, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read node ID: %w", err)
+	}
+
+	id := New()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("failed to store node ID: %w", err)
+	}
+
+	return id, nil
+}
+
+// New returns a random node ID
+func New() string {
+	b := make([]byte, Size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id is a hex encoded node ID
+func Valid(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == Size
+}
@@ -0,0 +1,61 @@
+package identity
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	folder := t.TempDir()
+
+	id, err := Load(folder)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !Valid(id) {
+		t.Errorf("Load() = %q, want a valid ID", id)
+	}
+
+	again, err := Load(folder)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if again != id {
+		t.Errorf("Load() = %q after restart, want %q", again, id)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	folder := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(folder, Dir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, Dir, "id"), []byte("not an id\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(folder); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Load() error = %v, want ErrInvalidID", err)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{New(), true},
+		{"", false},
+		{"9f86d0", false},
+		{"zz86d081884c7d659a2feaa0c55ad015a3bf4f1b", false},
+		{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b", true},
+	}
+
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.valid {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.valid)
+		}
+	}
+}
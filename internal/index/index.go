@@ -0,0 +1,361 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/identity"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/merkle"
+)
+
+const (
+	// DownloadingPrefix marks files that are still being downloaded, they
+	// are never shared
+	DownloadingPrefix = "downloading_"
+
+	// LinkPrefix starts a content link, followed by the hex encoded SHA-256
+	// of the content
+	LinkPrefix = "sha256:"
+)
+
+// Entry describes a shared file
+type Entry struct {
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Hash    string       // hex encoded SHA-256 of the content
+	Tree    *merkle.Tree // Merkle tree over ChunkSize chunks of the content
+}
+
+// fresh reports whether the file still matches the cached entry
+func (e Entry) fresh() bool {
+	info, err := os.Stat(e.Path)
+	if err != nil {
+		return false
+	}
+
+	return info.Size() == e.Size && info.ModTime().Equal(e.ModTime)
+}
+
+// Index maps file names in a shared folder to their metadata. Content
+// hashes and Merkle trees are cached and only recomputed when a file's size
+// or modification time changes.
+type Index struct {
+	folder  string
+	files   map[string]Entry // path -> entry
+	entries map[string]Entry // filename -> entry
+	hashes  map[string]Entry // content hash -> entry
+	log     logger.Logger
+	mutex   sync.RWMutex
+	// notify, if set, is called after a rebuild that changed the files
+	notify func()
+
+	// Folder walks run one at a time under walking, walks counts the ones
+	// started
+	walking sync.Mutex
+	walks   atomic.Uint64
+}
+
+func New(folder string, log logger.Logger) *Index {
+	i := &Index{
+		folder:  folder,
+		log:     log,
+		files:   make(map[string]Entry),
+		entries: make(map[string]Entry),
+		hashes:  make(map[string]Entry),
+	}
+
+	// Build initial file index
+	i.Rebuild()
+
+	return i
+}
+
+// SetNotify sets the function called after a rebuild that added, removed
+// or changed a file
+func (i *Index) SetNotify(notify func()) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.notify = notify
+}
+
+// Folder returns the shared folder
+func (i *Index) Folder() string {
+	return i.folder
+}
+
+// Rebuild scans the folder and rebuilds the index. Scans run one at a time:
+// callers that arrive while one is running share a single scan after it,
+// which sees every change made before they called, so a burst of calls
+// costs at most two scans.
+func (i *Index) Rebuild() {
+	// Any scan that starts after this one is as good
+	want := i.walks.Load() + 1
+
+	i.walking.Lock()
+	defer i.walking.Unlock()
+
+	if i.walks.Load() >= want {
+		return
+	}
+	i.walks.Add(1)
+
+	i.rebuild()
+}
+
+func (i *Index) rebuild() {
+	i.mutex.RLock()
+	previous := i.files
+	i.mutex.RUnlock()
+
+	files := make(map[string]Entry)
+	entries := make(map[string]Entry)
+	hashes := make(map[string]Entry)
+
+	err := filepath.Walk(i.folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip files with errors
+		}
+
+		if info.IsDir() {
+			// The node keeps its own state next to the shared files
+			if info.Name() == identity.Dir && path != i.folder {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), DownloadingPrefix) {
+			return nil
+		}
+
+		entry := Entry{
+			Name:    info.Name(),
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+
+		if old, ok := previous[path]; ok &&
+			old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) {
+			entry.Hash = old.Hash
+			entry.Tree = old.Tree
+		} else {
+			hash, tree, err := hashChunks(path)
+			if err != nil {
+				i.log.Warnf("Failed to hash %s: %v", path, err)
+				return nil
+			}
+			entry.Hash = hash
+			entry.Tree = tree
+		}
+
+		files[path] = entry
+		entries[entry.Name] = entry
+		// Files with the same name stay reachable through their content
+		hashes[entry.Hash] = entry
+		return nil
+	})
+
+	if err != nil {
+		i.log.Errorf("Error rebuilding file index: %v", err)
+	}
+
+	i.mutex.Lock()
+	i.files = files
+	i.entries = entries
+	i.hashes = hashes
+	notify := i.notify
+	i.mutex.Unlock()
+
+	if notify != nil && !sameFiles(previous, files) {
+		notify()
+	}
+}
+
+// sameFiles reports whether two scans found the same files with the same
+// content
+func sameFiles(a, b map[string]Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, entry := range a {
+		if other, ok := b[path]; !ok || other.Hash != entry.Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the cached entry for a filename without rescanning the folder
+func (i *Index) Get(filename string) (Entry, bool) {
+	// Sanitize filename to prevent path traversal
+	safeFilename := filepath.Base(filename)
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	entry, found := i.entries[safeFilename]
+	return entry, found
+}
+
+// Lookup returns the entry for a filename, rescanning the folder once if it
+// isn't indexed yet (the file might have been added) or the cached entry is
+// out of date
+func (i *Index) Lookup(filename string) (Entry, bool) {
+	if entry, found := i.Get(filename); found && entry.fresh() {
+		return entry, true
+	}
+
+	i.Rebuild()
+
+	return i.Get(filename)
+}
+
+// GetHash returns the cached entry for a content hash without rescanning
+// the folder
+func (i *Index) GetHash(hash string) (Entry, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	entry, found := i.hashes[strings.ToLower(hash)]
+	return entry, found
+}
+
+// LookupHash returns the entry for a content hash, rescanning the folder
+// once if it isn't indexed yet or the cached entry is out of date
+func (i *Index) LookupHash(hash string) (Entry, bool) {
+	if entry, found := i.GetHash(hash); found && entry.fresh() {
+		return entry, true
+	}
+
+	i.Rebuild()
+
+	return i.GetHash(hash)
+}
+
+// Entries returns all indexed files sorted by name, including files that
+// share a name with another one
+func (i *Index) Entries() []Entry {
+	i.mutex.RLock()
+	entries := make([]Entry, 0, len(i.files))
+	for _, entry := range i.files {
+		entries = append(entries, entry)
+	}
+	i.mutex.RUnlock()
+
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Name != entries[b].Name {
+			return entries[a].Name < entries[b].Name
+		}
+		return entries[a].Path < entries[b].Path
+	})
+
+	return entries
+}
+
+// Match returns the entries whose name matches query. A query containing
+// glob metacharacters is matched as a glob against the whole name,
+// anything else as a substring. Both ignore case.
+func (i *Index) Match(query string) []Entry {
+	query = strings.ToLower(query)
+	glob := strings.ContainsAny(query, "*?[")
+
+	matches := make([]Entry, 0)
+	for _, entry := range i.Entries() {
+		name := strings.ToLower(entry.Name)
+
+		var ok bool
+		if glob {
+			ok, _ = filepath.Match(query, name)
+		} else {
+			ok = strings.Contains(name, query)
+		}
+
+		if ok {
+			matches = append(matches, entry)
+		}
+	}
+
+	return matches
+}
+
+// Link returns the content link for a hash
+func Link(hash string) string {
+	return LinkPrefix + hash
+}
+
+// ParseLink extracts the hash from a content link. It reports false when s
+// is not a link, e.g. a plain file name.
+func ParseLink(s string) (string, bool) {
+	if !strings.HasPrefix(s, LinkPrefix) {
+		return "", false
+	}
+
+	hash := strings.ToLower(strings.TrimPrefix(s, LinkPrefix))
+	if len(hash) != 2*sha256.Size {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+
+	return hash, true
+}
+
+// HashFile returns the hex encoded SHA-256 of a file's content
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashChunks reads a file once and returns both its SHA-256 and the Merkle
+// tree over its ChunkSize chunks
+func hashChunks(path string) (string, *merkle.Tree, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	hash := sha256.New()
+	leaves := make([]merkle.Hash, 0)
+	buffer := make([]byte, config.ChunkSize)
+
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if n > 0 {
+			hash.Write(buffer[:n])
+			leaves = append(leaves, merkle.LeafHash(buffer[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), merkle.New(leaves), nil
+}
@@ -0,0 +1,306 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/identity"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/merkle"
+)
+
+// helloHash is the SHA-256 of "hello"
+const helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestLookup(t *testing.T) {
+	folder := t.TempDir()
+	writeFile(t, filepath.Join(folder, "a.txt"), "hello")
+
+	i := New(folder, logger.Discard)
+
+	entry, found := i.Lookup("a.txt")
+	if !found {
+		t.Fatal("Lookup() did not find indexed file")
+	}
+	if entry.Size != 5 {
+		t.Errorf("Size = %d, want %d", entry.Size, 5)
+	}
+	if entry.Hash != helloHash {
+		t.Errorf("Hash = %q, want %q", entry.Hash, helloHash)
+	}
+	if entry.Tree.Root() != merkle.LeafHash([]byte("hello")) {
+		t.Errorf("Tree root = %s, want the hash of the single chunk", entry.Tree.Root())
+	}
+
+	// Path traversal attempts resolve to the base name
+	if _, found := i.Lookup("../../a.txt"); !found {
+		t.Error("Lookup() should sanitize the file name")
+	}
+
+	// Files added later are found after a rescan
+	writeFile(t, filepath.Join(folder, "b.txt"), "world")
+	if _, found := i.Lookup("b.txt"); !found {
+		t.Error("Lookup() did not find a file added after New(, logger.Discard)")
+	}
+}
+
+func TestTreeChunks(t *testing.T) {
+	folder := t.TempDir()
+	content := strings.Repeat("x", 2*config.ChunkSize+1)
+	writeFile(t, filepath.Join(folder, "big.bin"), content)
+
+	entry, found := New(folder, logger.Discard).Lookup("big.bin")
+	if !found {
+		t.Fatal("Lookup() did not find indexed file")
+	}
+
+	if entry.Tree.Leaves() != 3 {
+		t.Fatalf("Tree leaves = %d, want %d", entry.Tree.Leaves(), 3)
+	}
+
+	last := merkle.LeafHash([]byte("x"))
+	if !merkle.Verify(entry.Tree.Root(), last, 2, 3, entry.Tree.Proof(2)) {
+		t.Error("last chunk does not verify against the tree root")
+	}
+}
+
+func TestLookupHash(t *testing.T) {
+	folder := t.TempDir()
+	if err := os.Mkdir(filepath.Join(folder, "old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(folder, "report.pdf"), "hello")
+	writeFile(t, filepath.Join(folder, "old", "report.pdf"), "goodbye")
+
+	i := New(folder, logger.Discard)
+
+	entry, found := i.LookupHash(helloHash)
+	if !found {
+		t.Fatal("LookupHash() did not find indexed content")
+	}
+	if entry.Path != filepath.Join(folder, "report.pdf") {
+		t.Errorf("Path = %q, want %q", entry.Path, filepath.Join(folder, "report.pdf"))
+	}
+
+	// Both files named report.pdf are indexed
+	if len(i.Entries()) != 2 {
+		t.Errorf("Entries() length = %d, want %d", len(i.Entries()), 2)
+	}
+
+	// Renamed content is still found by its hash
+	if err := os.Rename(filepath.Join(folder, "report.pdf"), filepath.Join(folder, "renamed.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	entry, found = i.LookupHash(strings.ToUpper(helloHash))
+	if !found || entry.Name != "renamed.pdf" {
+		t.Errorf("LookupHash() after rename = %q, %v, want renamed.pdf", entry.Name, found)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	folder := t.TempDir()
+	writeFile(t, filepath.Join(folder, "Report-2024.pdf"), "a")
+	writeFile(t, filepath.Join(folder, "report-2025.pdf"), "b")
+	writeFile(t, filepath.Join(folder, "notes.txt"), "c")
+
+	i := New(folder, logger.Discard)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{name: "substring", query: "report", expected: 2},
+		{name: "substring ignores case", query: "NOTES", expected: 1},
+		{name: "glob", query: "*.pdf", expected: 2},
+		{name: "glob matches whole name", query: "report*", expected: 2},
+		{name: "glob anchored at start", query: "*port", expected: 0},
+		{name: "glob with single character", query: "report-202?.pdf", expected: 2},
+		{name: "glob without match", query: "*.doc", expected: 0},
+		{name: "empty query matches everything", query: "", expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(i.Match(tt.query)); got != tt.expected {
+				t.Errorf("Match(%q) returned %d entries, want %d", tt.query, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		ok       bool
+	}{
+		{
+			name:     "valid link",
+			input:    Link(helloHash),
+			expected: helloHash,
+			ok:       true,
+		},
+		{
+			name:  "plain file name",
+			input: "report.pdf",
+			ok:    false,
+		},
+		{
+			name:  "short hash",
+			input: LinkPrefix + "2cf24d",
+			ok:    false,
+		},
+		{
+			name:  "not hex",
+			input: LinkPrefix + strings.Repeat("z", 64),
+			ok:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, ok := ParseLink(tt.input)
+			if ok != tt.ok || hash != tt.expected {
+				t.Errorf("ParseLink(%q) = %q, %v, want %q, %v", tt.input, hash, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSkipsPartialDownloads(t *testing.T) {
+	folder := t.TempDir()
+	writeFile(t, filepath.Join(folder, DownloadingPrefix+"a.txt"), "hel")
+
+	i := New(folder, logger.Discard)
+
+	if _, found := i.Lookup(DownloadingPrefix + "a.txt"); found {
+		t.Error("Lookup() should not index partial downloads")
+	}
+}
+
+func TestSkipsNodeState(t *testing.T) {
+	folder := t.TempDir()
+	if _, err := identity.Load(folder); err != nil {
+		t.Fatal(err)
+	}
+
+	i := New(folder, logger.Discard)
+
+	if _, found := i.Lookup("id"); found {
+		t.Errorf("Lookup() should not index the %s directory", identity.Dir)
+	}
+}
+
+func TestRebuildRehashesChangedFiles(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "a.txt")
+	writeFile(t, path, "hello")
+
+	i := New(folder, logger.Discard)
+
+	writeFile(t, path, "hello, world")
+	// Make sure the modification time changes even on coarse filesystems
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	i.Rebuild()
+
+	entry, _ := i.Get("a.txt")
+	if entry.Hash == helloHash {
+		t.Error("Rebuild() kept a stale hash for a modified file")
+	}
+}
+
+func TestEntriesSorted(t *testing.T) {
+	folder := t.TempDir()
+	writeFile(t, filepath.Join(folder, "b.txt"), "b")
+	writeFile(t, filepath.Join(folder, "a.txt"), "a")
+
+	entries := New(folder, logger.Discard).Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Entries() length = %d, want %d", len(entries), 2)
+	}
+	if entries[0].Name != "a.txt" || entries[1].Name != "b.txt" {
+		t.Errorf("Entries() = [%s %s], want [a.txt b.txt]", entries[0].Name, entries[1].Name)
+	}
+}
+
+func TestConcurrentRebuilds(t *testing.T) {
+	folder := t.TempDir()
+	writeFile(t, filepath.Join(folder, "a.txt"), "hello")
+
+	i := New(folder, logger.Discard)
+	walks := i.walks.Load()
+
+	// The calls arrive while a scan is running
+	i.walking.Lock()
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			i.Rebuild()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	i.walking.Unlock()
+	wg.Wait()
+
+	if n := i.walks.Load() - walks; n < 1 || n > 2 {
+		t.Errorf("50 concurrent Rebuild() calls walked the folder %d times, want them to share one", n)
+	}
+	if entry, ok := i.Get("a.txt"); !ok || entry.Hash != helloHash {
+		t.Errorf("Get() = %+v, %v after concurrent rebuilds", entry, ok)
+	}
+
+	// A file added after a rebuild shows up in the next one
+	writeFile(t, filepath.Join(folder, "b.txt"), "b")
+	i.Rebuild()
+	if _, ok := i.Get("b.txt"); !ok {
+		t.Error("Rebuild() missed a file added before it was called")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	folder := t.TempDir()
+	writeFile(t, filepath.Join(folder, "a.txt"), "hello")
+
+	i := New(folder, logger.Discard)
+	calls := 0
+	i.SetNotify(func() { calls++ })
+
+	i.Rebuild()
+	if calls != 0 {
+		t.Errorf("notified %d time(s) without a change", calls)
+	}
+
+	writeFile(t, filepath.Join(folder, "b.txt"), "b")
+	i.Rebuild()
+	if calls != 1 {
+		t.Errorf("notified %d time(s) after a file was added, want 1", calls)
+	}
+
+	if err := os.Remove(filepath.Join(folder, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	i.Rebuild()
+	if calls != 2 {
+		t.Errorf("notified %d time(s) after a file was removed, want 2", calls)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+// Logger receives what the services of a node have to say. Messages are
+// printf style and don't end with a newline.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	// Successf reports something that went well, like a file saved or a
+	// peer discovered
+	Successf(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// Discard drops every message
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debugf(string, ...any) {}
+
+func (discard) Infof(string, ...any) {}
+
+func (discard) Successf(string, ...any) {}
+
+func (discard) Warnf(string, ...any) {}
+
+func (discard) Errorf(string, ...any) {}
+
+// Terminal prints every message with the pterm prefix printers, for the
+// interactive UI. Debug messages only show when pterm's are enabled.
+var Terminal = TerminalLevel(slog.LevelDebug)
+
+// TerminalLevel prints the messages at level or above like Terminal,
+// successes count as info
+func TerminalLevel(level slog.Level) Logger {
+	return terminal{level: level}
+}
+
+type terminal struct {
+	level slog.Level
+}
+
+func (t terminal) print(level slog.Level, printer *pterm.PrefixPrinter, format string, args []any) {
+	if level < t.level {
+		return
+	}
+	printer.Printf(format+"\n", args...)
+}
+
+func (t terminal) Debugf(format string, args ...any) {
+	t.print(slog.LevelDebug, &pterm.Debug, format, args)
+}
+
+func (t terminal) Infof(format string, args ...any) {
+	t.print(slog.LevelInfo, &pterm.Info, format, args)
+}
+
+func (t terminal) Successf(format string, args ...any) {
+	t.print(slog.LevelInfo, &pterm.Success, format, args)
+}
+
+func (t terminal) Warnf(format string, args ...any) {
+	t.print(slog.LevelWarn, &pterm.Warning, format, args)
+}
+
+func (t terminal) Errorf(format string, args ...any) {
+	t.print(slog.LevelError, &pterm.Error, format, args)
+}
+
+// Slog writes every message as a record of l, successes at the info level
+// with success=true, for daemons
+func Slog(l *slog.Logger) Logger {
+	return slogger{l: l}
+}
+
+type slogger struct {
+	l *slog.Logger
+}
+
+func (s slogger) log(level slog.Level, format string, args []any, attrs ...slog.Attr) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+	s.l.LogAttrs(ctx, level, fmt.Sprintf(format, args...), attrs...)
+}
+
+func (s slogger) Debugf(format string, args ...any) {
+	s.log(slog.LevelDebug, format, args)
+}
+
+func (s slogger) Infof(format string, args ...any) {
+	s.log(slog.LevelInfo, format, args)
+}
+
+func (s slogger) Successf(format string, args ...any) {
+	s.log(slog.LevelInfo, format, args, slog.Bool("success", true))
+}
+
+func (s slogger) Warnf(format string, args ...any) {
+	s.log(slog.LevelWarn, format, args)
+}
+
+func (s slogger) Errorf(format string, args ...any) {
+	s.log(slog.LevelError, format, args)
+}
+
+// ParseLevel parses a level name: debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", name)
+	}
+	return level, nil
+}
@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pterm/pterm"
+)
+
+func TestSlog(t *testing.T) {
+	var buffer bytes.Buffer
+	log := Slog(slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	log.Debugf("hidden %d", 1)
+	log.Successf("saved %s", "a.txt")
+	log.Warnf("peer %s is suspect", "10.0.0.1:1378")
+
+	decoder := json.NewDecoder(&buffer)
+	want := []struct {
+		level   string
+		msg     string
+		success bool
+	}{
+		{level: "INFO", msg: "saved a.txt", success: true},
+		{level: "WARN", msg: "peer 10.0.0.1:1378 is suspect"},
+	}
+
+	for _, w := range want {
+		var record struct {
+			Level   string `json:"level"`
+			Msg     string `json:"msg"`
+			Success bool   `json:"success"`
+		}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Decode() error = %v, want a record for %q", err, w.msg)
+		}
+		if record.Level != w.level || record.Msg != w.msg || record.Success != w.success {
+			t.Errorf("record = %+v, want %+v", record, w)
+		}
+	}
+
+	if decoder.More() {
+		t.Errorf("unexpected records after the expected ones")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		want slog.Level
+		ok   bool
+	}{
+		{name: "debug", want: slog.LevelDebug, ok: true},
+		{name: "INFO", want: slog.LevelInfo, ok: true},
+		{name: " warn", want: slog.LevelWarn, ok: true},
+		{name: "error", want: slog.LevelError, ok: true},
+		{name: "loud"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestTerminalLevel(t *testing.T) {
+	var buffer bytes.Buffer
+	pterm.SetDefaultOutput(&buffer)
+	pterm.DisableStyling()
+	t.Cleanup(func() {
+		pterm.SetDefaultOutput(os.Stdout)
+		pterm.EnableStyling()
+	})
+
+	log := TerminalLevel(slog.LevelWarn)
+	log.Infof("hidden %d", 1)
+	log.Successf("hidden %d", 2)
+	log.Warnf("peer %s is suspect", "10.0.0.1:1378")
+	log.Errorf("shutting down")
+
+	output := buffer.String()
+	if strings.Contains(output, "hidden") {
+		t.Errorf("output = %q, want the messages below warn left out", output)
+	}
+	for _, msg := range []string{"peer 10.0.0.1:1378 is suspect", "shutting down"} {
+		if !strings.Contains(output, msg) {
+			t.Errorf("output = %q, want %q", output, msg)
+		}
+	}
+}
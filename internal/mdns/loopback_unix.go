@@ -0,0 +1,26 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package mdns
+
+import (
+	"net"
+	"syscall"
+)
+
+// setLoopback has the multicast traffic of conn delivered to this host as
+// well, which net.ListenMulticastUDP turns off, so nodes sharing a host
+// find each other
+func setLoopback(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
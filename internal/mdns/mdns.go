@@ -0,0 +1,351 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/logger"
+)
+
+const (
+	// ServiceType is the DNS-SD service the nodes advertise
+	ServiceType = "_p2p._udp.local."
+
+	// servicesName lists the service types on the network
+	servicesName = "_services._dns-sd._udp.local."
+
+	// hostTTL is for records tied to the host, serviceTTL for the rest, as
+	// recommended by RFC 6762
+	hostTTL    = 120
+	serviceTTL = 4500
+)
+
+// Group is the mDNS multicast group
+var Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is a DNS-SD service instance (RFC 6763) this node advertises
+// over multicast DNS (RFC 6762)
+type Service struct {
+	// Instance is the instance label, Host the host label, both under local.
+	Instance string
+	Host     string
+	IPs      []net.IP
+	Port     int
+	Text     []string
+}
+
+// Entry is a service instance found on the network
+type Entry struct {
+	// Instance is the full instance name, Host the full host name
+	Instance string
+	Host     string
+	IPs      []net.IP
+	Port     int
+	Text     map[string]string
+}
+
+// InstanceName returns the full name of the service instance
+func (s *Service) InstanceName() string {
+	return s.Instance + "." + ServiceType
+}
+
+// HostName returns the full host name
+func (s *Service) HostName() string {
+	return s.Host + ".local."
+}
+
+func (s *Service) ptr(ttl uint32) Record {
+	return Record{Name: ServiceType, Type: TypePTR, TTL: ttl, Target: s.InstanceName()}
+}
+
+func (s *Service) srv(ttl uint32) Record {
+	return Record{
+		Name: s.InstanceName(), Type: TypeSRV, CacheFlush: true, TTL: ttl,
+		Port: uint16(s.Port), Target: s.HostName(),
+	}
+}
+
+func (s *Service) txt(ttl uint32) Record {
+	return Record{Name: s.InstanceName(), Type: TypeTXT, CacheFlush: true, TTL: ttl, Text: s.Text}
+}
+
+func (s *Service) addresses(ttl uint32) []Record {
+	records := make([]Record, 0, len(s.IPs))
+	for _, ip := range s.IPs {
+		r := Record{Name: s.HostName(), Type: TypeAAAA, CacheFlush: true, TTL: ttl, IP: ip}
+		if ip.To4() != nil {
+			r.Type = TypeA
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// Answer returns the response to a query, or nil when the query isn't
+// about this service. Records the querier lists as known answers with
+// more than half their TTL left are left out.
+func (s *Service) Answer(query *Message) *Message {
+	resp := &Message{Response: true}
+
+	add := func(records *[]Record, r Record) {
+		for _, known := range query.Answers {
+			if same(known, r) && known.TTL > r.TTL/2 {
+				return
+			}
+		}
+		for _, existing := range slices.Concat(resp.Answers, resp.Additionals) {
+			if same(existing, r) {
+				return
+			}
+		}
+		*records = append(*records, r)
+	}
+	matches := func(q Question, name string, types ...uint16) bool {
+		if !strings.EqualFold(q.Name, name) {
+			return false
+		}
+		for _, t := range types {
+			if q.Type == t || q.Type == TypeANY {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, q := range query.Questions {
+		switch {
+		case matches(q, servicesName, TypePTR):
+			add(&resp.Answers, Record{Name: servicesName, Type: TypePTR, TTL: serviceTTL, Target: ServiceType})
+
+		case matches(q, ServiceType, TypePTR):
+			add(&resp.Answers, s.ptr(serviceTTL))
+			add(&resp.Additionals, s.srv(hostTTL))
+			add(&resp.Additionals, s.txt(serviceTTL))
+			for _, r := range s.addresses(hostTTL) {
+				add(&resp.Additionals, r)
+			}
+
+		case matches(q, s.InstanceName(), TypeSRV, TypeTXT):
+			if q.Type == TypeSRV || q.Type == TypeANY {
+				add(&resp.Answers, s.srv(hostTTL))
+			}
+			if q.Type == TypeTXT || q.Type == TypeANY {
+				add(&resp.Answers, s.txt(serviceTTL))
+			}
+			for _, r := range s.addresses(hostTTL) {
+				add(&resp.Additionals, r)
+			}
+
+		case matches(q, s.HostName(), TypeA, TypeAAAA):
+			for _, r := range s.addresses(hostTTL) {
+				if q.Type == r.Type || q.Type == TypeANY {
+					add(&resp.Answers, r)
+				}
+			}
+		}
+	}
+
+	if len(resp.Answers) == 0 {
+		return nil
+	}
+	return resp
+}
+
+// Announcement returns all the records of the service as an unsolicited
+// response. A goodbye announcement has them expire right away.
+func (s *Service) Announcement(goodbye bool) *Message {
+	var host, service uint32 = hostTTL, serviceTTL
+	if goodbye {
+		host, service = 0, 0
+	}
+
+	resp := &Message{Response: true}
+	resp.Answers = append(resp.Answers, s.ptr(service), s.srv(host), s.txt(service))
+	resp.Answers = append(resp.Answers, s.addresses(host)...)
+	return resp
+}
+
+// Query returns a query for the instances of the service
+func Query() *Message {
+	return &Message{Questions: []Question{{Name: ServiceType, Type: TypePTR}}}
+}
+
+// Entries returns the service instances a response describes. Instances
+// without an SRV record are left out since their port isn't known.
+func Entries(m *Message) []Entry {
+	records := slices.Concat(m.Answers, m.Additionals)
+
+	var entries []Entry
+	for _, srv := range records {
+		if srv.Type != TypeSRV || srv.TTL == 0 || !strings.HasSuffix(strings.ToLower(srv.Name), ServiceType) {
+			continue
+		}
+
+		entry := Entry{Instance: srv.Name, Host: srv.Target, Port: int(srv.Port), Text: make(map[string]string)}
+		for _, r := range records {
+			switch {
+			case r.Type == TypeTXT && strings.EqualFold(r.Name, srv.Name):
+				for _, kv := range r.Text {
+					key, value, _ := strings.Cut(kv, "=")
+					entry.Text[strings.ToLower(key)] = value
+				}
+			case (r.Type == TypeA || r.Type == TypeAAAA) && strings.EqualFold(r.Name, srv.Target):
+				entry.IPs = append(entry.IPs, r.IP)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Serve advertises the service and browses for other instances until ctx
+// is done. Queries for the service are answered, the network is queried
+// every interval, and found is called for every instance other than our
+// own that a response describes, along with the address it came from.
+func Serve(ctx context.Context, service *Service, interval time.Duration, log logger.Logger, found func(Entry, *net.UDPAddr)) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, Group)
+	if err != nil {
+		return fmt.Errorf("failed to listen for mDNS: %w", err)
+	}
+	if err := setLoopback(conn); err != nil {
+		log.Warnf("Nodes on this host won't see each other over mDNS: %v", err)
+	}
+	log.Successf("Advertising %s on mDNS as %s", service.InstanceName(), service.HostName())
+
+	go func() {
+		<-ctx.Done()
+		// Let the other nodes forget us right away
+		send(conn, Group, service.Announcement(true), log)
+		_ = conn.Close()
+	}()
+
+	go func() {
+		send(conn, Group, service.Announcement(false), log)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			send(conn, Group, Query(), log)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buffer := make([]byte, maxMessage)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+				log.Errorf("mDNS read error: %v", err)
+				continue
+			}
+		}
+
+		m, err := Unmarshal(buffer[:n])
+		if err != nil {
+			log.Debugf("Ignoring malformed mDNS message from %s: %v", remoteAddr.String(), err)
+			continue
+		}
+
+		if m.Response {
+			for _, entry := range Entries(m) {
+				if !strings.EqualFold(entry.Instance, service.InstanceName()) {
+					found(entry, remoteAddr)
+				}
+			}
+			continue
+		}
+
+		resp := service.Answer(m)
+		if resp == nil {
+			continue
+		}
+		if unicast(m, remoteAddr) {
+			// One-shot queriers only listen for a direct reply, which has
+			// to echo the query ID and questions
+			resp.ID = m.ID
+			resp.Questions = m.Questions
+			send(conn, remoteAddr, resp, log)
+		} else {
+			send(conn, Group, resp, log)
+		}
+	}
+}
+
+// unicast reports whether a query wants a direct reply, either by asking
+// for it or by coming from a port other than the mDNS one
+func unicast(m *Message, from *net.UDPAddr) bool {
+	if from.Port != Group.Port {
+		return true
+	}
+	for _, q := range m.Questions {
+		if q.Unicast {
+			return true
+		}
+	}
+	return false
+}
+
+func send(conn *net.UDPConn, addr *net.UDPAddr, m *Message, log logger.Logger) {
+	b, err := m.Marshal()
+	if err != nil {
+		log.Errorf("Failed to encode mDNS message: %v", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(b, addr); err != nil {
+		log.Debugf("Failed to send mDNS message to %s: %v", addr.String(), err)
+	}
+}
+
+// same reports whether two records carry the same data
+func same(a, b Record) bool {
+	if !strings.EqualFold(a.Name, b.Name) || a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case TypePTR:
+		return strings.EqualFold(a.Target, b.Target)
+	case TypeSRV:
+		return strings.EqualFold(a.Target, b.Target) && a.Port == b.Port
+	case TypeTXT:
+		return strings.Join(a.Text, "\x00") == strings.Join(b.Text, "\x00")
+	case TypeA, TypeAAAA:
+		return a.IP.Equal(b.IP)
+	default:
+		return string(a.Data) == string(b.Data)
+	}
+}
+
+// LocalIPs returns the addresses to advertise for a socket bound to host:
+// host itself when it is an IP, otherwise the addresses of the non-loopback
+// interfaces it listens on as well
+func LocalIPs(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return []net.IP{ip}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,110 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+)
+
+func service() *Service {
+	return &Service{
+		Instance: "p2p-00ff",
+		Host:     "p2p-00ff",
+		IPs:      []net.IP{net.IPv4(192, 168, 1, 2)},
+		Port:     1378,
+		Text:     []string{"id=00ff", "tcp=33680"},
+	}
+}
+
+func TestAnswerBrowse(t *testing.T) {
+	resp := service().Answer(Query())
+	if resp == nil {
+		t.Fatal("Answer() = nil, want a response")
+	}
+
+	if len(resp.Answers) != 1 || resp.Answers[0].Target != "p2p-00ff._p2p._udp.local." {
+		t.Errorf("Answers = %+v, want the instance PTR", resp.Answers)
+	}
+	types := make(map[uint16]bool)
+	for _, r := range resp.Additionals {
+		types[r.Type] = true
+	}
+	if !types[TypeSRV] || !types[TypeTXT] || !types[TypeA] {
+		t.Errorf("Additionals = %+v, want SRV, TXT and A", resp.Additionals)
+	}
+}
+
+func TestAnswerHostname(t *testing.T) {
+	query := &Message{Questions: []Question{{Name: "P2P-00FF.local.", Type: TypeA}}}
+
+	resp := service().Answer(query)
+	if resp == nil || len(resp.Answers) != 1 || !resp.Answers[0].IP.Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Errorf("Answer() = %+v, want the host address", resp)
+	}
+}
+
+func TestAnswerKnownAnswers(t *testing.T) {
+	s := service()
+	query := Query()
+	query.Answers = []Record{s.ptr(serviceTTL)}
+
+	if resp := s.Answer(query); resp != nil {
+		t.Errorf("Answer() = %+v, want nothing for a known answer", resp)
+	}
+
+	// An answer about to expire is sent again
+	query.Answers[0].TTL = 10
+	if resp := s.Answer(query); resp == nil {
+		t.Errorf("Answer() = nil, want a refresh of an expiring answer")
+	}
+}
+
+func TestAnswerUnrelated(t *testing.T) {
+	query := &Message{Questions: []Question{{Name: "_http._tcp.local.", Type: TypePTR}}}
+	if resp := service().Answer(query); resp != nil {
+		t.Errorf("Answer() = %+v, want nil", resp)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	b, err := service().Announcement(false).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	m, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	entries := Entries(m)
+	if len(entries) != 1 {
+		t.Fatalf("Entries() = %+v, want one entry", entries)
+	}
+	e := entries[0]
+	if e.Instance != "p2p-00ff._p2p._udp.local." || e.Host != "p2p-00ff.local." || e.Port != 1378 {
+		t.Errorf("entry = %+v", e)
+	}
+	if e.Text["id"] != "00ff" || e.Text["tcp"] != "33680" {
+		t.Errorf("entry text = %v", e.Text)
+	}
+	if len(e.IPs) != 1 || !e.IPs[0].Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Errorf("entry addresses = %v", e.IPs)
+	}
+
+	if entries := Entries(service().Announcement(true)); len(entries) != 0 {
+		t.Errorf("Entries() = %+v for a goodbye, want none", entries)
+	}
+}
+
+func TestLocalIPs(t *testing.T) {
+	for _, host := range []string{"192.168.1.2", "127.0.0.1"} {
+		if ips := LocalIPs(host); len(ips) != 1 || !ips[0].Equal(net.ParseIP(host)) {
+			t.Errorf("LocalIPs(%q) = %v, want the address bound to", host, ips)
+		}
+	}
+
+	for _, ip := range LocalIPs("0.0.0.0") {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			t.Errorf("LocalIPs(\"0.0.0.0\") lists %v, want interface addresses only", ip)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Record types used by DNS-SD
+const (
+	TypeA    uint16 = 1
+	TypePTR  uint16 = 12
+	TypeTXT  uint16 = 16
+	TypeAAAA uint16 = 28
+	TypeSRV  uint16 = 33
+	TypeANY  uint16 = 255
+)
+
+const (
+	// ClassIN is the internet class, the only one mDNS uses
+	ClassIN uint16 = 1
+
+	// The top bit of the class is the unicast response bit in questions and
+	// the cache flush bit in records
+	classFlag uint16 = 0x8000
+
+	// flagResponse marks a message as a response, flagAuthoritative as
+	// coming from the owner of the records
+	flagResponse      uint16 = 0x8000
+	flagAuthoritative uint16 = 0x0400
+
+	headerSize = 12
+
+	// maxMessage is the largest mDNS message
+	maxMessage = 9000
+
+	// maxLabel and maxName are the DNS limits on label and name lengths
+	maxLabel = 63
+	maxName  = 255
+)
+
+var (
+	ErrShortMessage = errors.New("message too short")
+	ErrInvalidName  = errors.New("invalid domain name")
+)
+
+// Message is a DNS message as used by mDNS. Authority records are parsed
+// but not kept, mDNS only uses them for probing.
+type Message struct {
+	ID          uint16
+	Response    bool
+	Questions   []Question
+	Answers     []Record
+	Additionals []Record
+}
+
+// Question asks for the records of a name. Unicast asks the responder to
+// reply directly instead of to the multicast group.
+type Question struct {
+	Name    string
+	Type    uint16
+	Unicast bool
+}
+
+// Record is a resource record. Which of the data fields is set depends on
+// the type: Target for PTR, Target, Port, Priority and Weight for SRV, Text
+// for TXT and IP for A and AAAA. Data holds the raw data of other types.
+type Record struct {
+	Name       string
+	Type       uint16
+	CacheFlush bool
+	TTL        uint32
+
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	Text     []string
+	IP       net.IP
+	Data     []byte
+}
+
+// Marshal encodes the message. Names are written out in full, compression
+// is optional for senders.
+func (m *Message) Marshal() ([]byte, error) {
+	b := make([]byte, headerSize, 512)
+
+	var flags uint16
+	if m.Response {
+		flags = flagResponse | flagAuthoritative
+	}
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additionals)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		class := ClassIN
+		if q.Unicast {
+			class |= classFlag
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, class)
+	}
+
+	for _, r := range slices.Concat(m.Answers, m.Additionals) {
+		if b, err = r.append(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func (r *Record) append(b []byte) ([]byte, error) {
+	b, err := appendName(b, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	class := ClassIN
+	if r.CacheFlush {
+		class |= classFlag
+	}
+	b = binary.BigEndian.AppendUint16(b, r.Type)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, r.TTL)
+
+	// Reserve the data length and fill it in once the data is written
+	length := len(b)
+	b = append(b, 0, 0)
+
+	switch r.Type {
+	case TypePTR:
+		b, err = appendName(b, r.Target)
+	case TypeSRV:
+		b = binary.BigEndian.AppendUint16(b, r.Priority)
+		b = binary.BigEndian.AppendUint16(b, r.Weight)
+		b = binary.BigEndian.AppendUint16(b, r.Port)
+		b, err = appendName(b, r.Target)
+	case TypeTXT:
+		if len(r.Text) == 0 {
+			// A TXT record holds at least one, possibly empty, string
+			b = append(b, 0)
+		}
+		for _, s := range r.Text {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string too long: %q", s)
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case TypeA:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("A record needs an IPv4 address: %v", r.IP)
+		}
+		b = append(b, ip...)
+	case TypeAAAA:
+		ip := r.IP.To16()
+		if ip == nil {
+			return nil, fmt.Errorf("AAAA record needs an IPv6 address: %v", r.IP)
+		}
+		b = append(b, ip...)
+	default:
+		b = append(b, r.Data...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint16(b[length:], uint16(len(b)-length-2))
+	return b, nil
+}
+
+// appendName encodes a domain name as a sequence of labels
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name)+2 > maxName {
+		return nil, fmt.Errorf("%w: %q is too long", ErrInvalidName, name)
+	}
+
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > maxLabel {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// Unmarshal decodes a DNS message
+func Unmarshal(b []byte) (*Message, error) {
+	if len(b) < headerSize {
+		return nil, ErrShortMessage
+	}
+
+	m := &Message{
+		ID:       binary.BigEndian.Uint16(b[0:]),
+		Response: binary.BigEndian.Uint16(b[2:])&flagResponse != 0,
+	}
+	questions := int(binary.BigEndian.Uint16(b[4:]))
+	answers := int(binary.BigEndian.Uint16(b[6:]))
+	authorities := int(binary.BigEndian.Uint16(b[8:]))
+	additionals := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := headerSize
+	for range questions {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, ErrShortMessage
+		}
+
+		class := binary.BigEndian.Uint16(b[off+2:])
+		m.Questions = append(m.Questions, Question{
+			Name:    name,
+			Type:    binary.BigEndian.Uint16(b[off:]),
+			Unicast: class&classFlag != 0,
+		})
+		off += 4
+	}
+
+	for i := range answers + authorities + additionals {
+		r, n, err := readRecord(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+
+		switch {
+		case i < answers:
+			m.Answers = append(m.Answers, r)
+		case i >= answers+authorities:
+			m.Additionals = append(m.Additionals, r)
+		}
+	}
+
+	return m, nil
+}
+
+func readRecord(b []byte, off int) (Record, int, error) {
+	name, off, err := readName(b, off)
+	if err != nil {
+		return Record{}, 0, err
+	}
+	if off+10 > len(b) {
+		return Record{}, 0, ErrShortMessage
+	}
+
+	class := binary.BigEndian.Uint16(b[off+2:])
+	r := Record{
+		Name:       name,
+		Type:       binary.BigEndian.Uint16(b[off:]),
+		CacheFlush: class&classFlag != 0,
+		TTL:        binary.BigEndian.Uint32(b[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+length > len(b) {
+		return Record{}, 0, ErrShortMessage
+	}
+	data := b[off : off+length]
+
+	switch r.Type {
+	case TypePTR:
+		// Names in the data may point back into the rest of the message
+		r.Target, _, err = readName(b, off)
+	case TypeSRV:
+		if length < 7 {
+			return Record{}, 0, ErrShortMessage
+		}
+		r.Priority = binary.BigEndian.Uint16(data[0:])
+		r.Weight = binary.BigEndian.Uint16(data[2:])
+		r.Port = binary.BigEndian.Uint16(data[4:])
+		r.Target, _, err = readName(b, off+6)
+	case TypeTXT:
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return Record{}, 0, ErrShortMessage
+			}
+			if n > 0 {
+				r.Text = append(r.Text, string(data[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	case TypeA, TypeAAAA:
+		if (r.Type == TypeA && length != net.IPv4len) || (r.Type == TypeAAAA && length != net.IPv6len) {
+			return Record{}, 0, fmt.Errorf("%w: address of %d bytes", ErrShortMessage, length)
+		}
+		r.IP = net.IP(append([]byte(nil), data...))
+	default:
+		r.Data = append([]byte(nil), data...)
+	}
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	return r, off + length, nil
+}
+
+// readName decodes the domain name at off, following compression
+// pointers, and returns it with a trailing dot along with the offset right
+// after it
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	length := 0
+
+	// Every pointer must go backwards, which rules out loops
+	limit := off
+	for {
+		if off >= len(b) {
+			return "", 0, ErrShortMessage
+		}
+
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, ErrShortMessage
+			}
+			ptr := int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			if ptr >= limit {
+				return "", 0, fmt.Errorf("%w: bad compression pointer", ErrInvalidName)
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off, limit = ptr, ptr
+
+		case n > maxLabel:
+			return "", 0, fmt.Errorf("%w: label of %d bytes", ErrInvalidName, n)
+
+		default:
+			if off+1+n > len(b) {
+				return "", 0, ErrShortMessage
+			}
+			length += n + 1
+			if length > maxName {
+				return "", 0, fmt.Errorf("%w: name too long", ErrInvalidName)
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
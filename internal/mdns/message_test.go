@@ -0,0 +1,103 @@
+package mdns
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		ID:       7,
+		Response: true,
+		Questions: []Question{
+			{Name: "_p2p._udp.local.", Type: TypePTR, Unicast: true},
+		},
+		Answers: []Record{
+			{Name: "_p2p._udp.local.", Type: TypePTR, TTL: 4500, Target: "node._p2p._udp.local."},
+			{Name: "node._p2p._udp.local.", Type: TypeSRV, CacheFlush: true, TTL: 120, Priority: 1, Weight: 2, Port: 1378, Target: "node.local."},
+			{Name: "node._p2p._udp.local.", Type: TypeTXT, TTL: 4500, Text: []string{"id=00ff", "tcp=33680"}},
+		},
+		Additionals: []Record{
+			{Name: "node.local.", Type: TypeA, TTL: 120, IP: net.IPv4(192, 168, 1, 2).To4()},
+			{Name: "node.local.", Type: TypeAAAA, TTL: 120, IP: net.ParseIP("fe80::1")},
+			{Name: "node.local.", Type: 47, TTL: 120, Data: []byte{1, 2, 3}},
+		},
+	}
+
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	got, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, m)
+	}
+}
+
+func TestUnmarshalCompressedNames(t *testing.T) {
+	b := []byte{
+		0x00, 0x00, 0x84, 0x00, // ID, flags
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, // one answer
+		// _p2p._udp.local. at offset 12
+		0x04, '_', 'p', '2', 'p', 0x04, '_', 'u', 'd', 'p', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00,
+		0x00, 0x0c, 0x00, 0x01, // PTR, IN
+		0x00, 0x00, 0x11, 0x94, // TTL 4500
+		0x00, 0x07, // data length
+		// node + pointer to _p2p._udp.local.
+		0x04, 'n', 'o', 'd', 'e', 0xc0, 0x0c,
+	}
+
+	m, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(m.Answers) != 1 || m.Answers[0].Target != "node._p2p._udp.local." || !m.Response {
+		t.Errorf("Unmarshal() = %+v, want a PTR to node._p2p._udp.local.", m)
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{name: "short header", b: []byte{0, 0, 0}, err: ErrShortMessage},
+		{
+			name: "truncated question",
+			b:    []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 4, 'n', 'o'},
+			err:  ErrShortMessage,
+		},
+		{
+			name: "pointer loop",
+			b:    []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 0x0c, 0, 1, 0, 1},
+			err:  ErrInvalidName,
+		},
+		{
+			name: "label too long",
+			b:    []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0x40},
+			err:  ErrInvalidName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unmarshal(tt.b); !errors.Is(err, tt.err) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMarshalInvalidName(t *testing.T) {
+	m := &Message{Questions: []Question{{Name: "a..local.", Type: TypeA}}}
+	if _, err := m.Marshal(); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Marshal() error = %v, want ErrInvalidName", err)
+	}
+}
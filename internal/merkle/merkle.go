@@ -0,0 +1,146 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so a leaf can
+// never be passed off as an inner node
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+var ErrInvalidHash = errors.New("invalid merkle hash")
+
+// Hash is a node of the tree
+type Hash [sha256.Size]byte
+
+// String returns the hex encoding of the hash
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// ParseHash decodes a hex encoded hash
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, fmt.Errorf("%w: %q", ErrInvalidHash, s)
+	}
+
+	copy(h[:], b)
+	return h, nil
+}
+
+// LeafHash returns the hash of a chunk of data
+func LeafHash(data []byte) Hash {
+	hash := sha256.New()
+	hash.Write([]byte{leafPrefix})
+	hash.Write(data)
+
+	var h Hash
+	copy(h[:], hash.Sum(nil))
+	return h
+}
+
+func nodeHash(left, right Hash) Hash {
+	hash := sha256.New()
+	hash.Write([]byte{nodePrefix})
+	hash.Write(left[:])
+	hash.Write(right[:])
+
+	var h Hash
+	copy(h[:], hash.Sum(nil))
+	return h
+}
+
+// Tree is a binary Merkle tree over chunk hashes. A node without a sibling
+// is promoted to the next level unchanged.
+type Tree struct {
+	levels [][]Hash // levels[0] holds the leaves, the last level the root
+}
+
+// New builds a tree from leaf hashes. An empty list is treated as a single
+// empty chunk.
+func New(leaves []Hash) *Tree {
+	if len(leaves) == 0 {
+		leaves = []Hash{LeafHash(nil)}
+	}
+
+	level := make([]Hash, len(leaves))
+	copy(level, leaves)
+
+	levels := [][]Hash{level}
+	for len(level) > 1 {
+		next := make([]Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return &Tree{levels: levels}
+}
+
+// Root returns the root hash
+func (t *Tree) Root() Hash {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Leaves returns the number of leaves
+func (t *Tree) Leaves() int {
+	return len(t.levels[0])
+}
+
+// Proof returns the sibling hashes needed to recompute the root from the
+// leaf at index, ordered from the bottom of the tree up
+func (t *Tree) Proof(index int) []Hash {
+	proof := make([]Hash, 0, len(t.levels))
+
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+
+	return proof
+}
+
+// Verify checks that leaf is the chunk at index of a tree with count
+// leaves and the given root
+func Verify(root, leaf Hash, index, count int, proof []Hash) bool {
+	if index < 0 || index >= count {
+		return false
+	}
+
+	hash := leaf
+	for width := count; width > 1; width = (width + 1) / 2 {
+		sibling := index ^ 1
+		if sibling < width {
+			if len(proof) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				hash = nodeHash(hash, proof[0])
+			} else {
+				hash = nodeHash(proof[0], hash)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+
+	return len(proof) == 0 && hash == root
+}
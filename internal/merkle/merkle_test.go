@@ -0,0 +1,111 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+)
+
+func leaves(n int) []Hash {
+	hashes := make([]Hash, n)
+	for i := range hashes {
+		hashes[i] = LeafHash([]byte(fmt.Sprintf("chunk-%d", i)))
+	}
+	return hashes
+}
+
+func TestSingleLeafRoot(t *testing.T) {
+	leaf := LeafHash([]byte("only"))
+	tree := New([]Hash{leaf})
+
+	if tree.Root() != leaf {
+		t.Errorf("Root() = %s, want the leaf hash %s", tree.Root(), leaf)
+	}
+	if len(tree.Proof(0)) != 0 {
+		t.Errorf("Proof(0) length = %d, want %d", len(tree.Proof(0)), 0)
+	}
+}
+
+func TestEmptyTree(t *testing.T) {
+	tree := New(nil)
+
+	if tree.Leaves() != 1 {
+		t.Errorf("Leaves() = %d, want %d", tree.Leaves(), 1)
+	}
+	if tree.Root() != LeafHash(nil) {
+		t.Errorf("Root() = %s, want hash of an empty chunk", tree.Root())
+	}
+}
+
+func TestProofVerify(t *testing.T) {
+	for count := 1; count <= 17; count++ {
+		hashes := leaves(count)
+		tree := New(hashes)
+
+		for i := 0; i < count; i++ {
+			if !Verify(tree.Root(), hashes[i], i, count, tree.Proof(i)) {
+				t.Errorf("Verify() failed for leaf %d of %d", i, count)
+			}
+		}
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	hashes := leaves(7)
+	tree := New(hashes)
+
+	tests := []struct {
+		name  string
+		leaf  Hash
+		index int
+		proof []Hash
+	}{
+		{
+			name:  "wrong leaf",
+			leaf:  LeafHash([]byte("evil")),
+			index: 3,
+			proof: tree.Proof(3),
+		},
+		{
+			name:  "wrong index",
+			leaf:  hashes[3],
+			index: 2,
+			proof: tree.Proof(3),
+		},
+		{
+			name:  "truncated proof",
+			leaf:  hashes[3],
+			index: 3,
+			proof: tree.Proof(3)[1:],
+		},
+		{
+			name:  "out of range index",
+			leaf:  hashes[3],
+			index: 7,
+			proof: tree.Proof(3),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Verify(tree.Root(), tt.leaf, tt.index, 7, tt.proof) {
+				t.Error("Verify() accepted a tampered proof")
+			}
+		})
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	hash := LeafHash([]byte("data"))
+
+	parsed, err := ParseHash(hash.String())
+	if err != nil {
+		t.Fatalf("ParseHash() error: %v", err)
+	}
+	if parsed != hash {
+		t.Errorf("ParseHash() = %s, want %s", parsed, hash)
+	}
+
+	if _, err := ParseHash("abc"); err == nil {
+		t.Error("ParseHash() expected error for short input")
+	}
+}
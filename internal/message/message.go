@@ -1,8 +1,11 @@
 package message
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -14,36 +17,505 @@ var (
 	ErrUnknownMessage   = errors.New("unknown message type")
 	ErrInvalidPort      = errors.New("invalid port number")
 	ErrInvalidMethod    = errors.New("invalid transfer method")
+	ErrInvalidRange     = errors.New("invalid byte range")
+	ErrInvalidUpdate    = errors.New("invalid membership update")
 )
 
 type Message interface {
 	Marshal() string
 }
 
-type Discover struct {
-	List []string
+// Get requests a file by name, escaped like a Match's. ID correlates the File replies with the
+// search that triggered them. Offset and Length select a byte range on the
+// TCP transfer protocol, a zero Length means "until the end of the file".
+// TTL is how many more hops the request is forwarded and Origin the UDP
+// address of the node that sent it first, both zero for a request that
+// isn't forwarded.
+type Get struct {
+	ID     string
+	Name   string
+	Offset int64
+	Length int64
+	TTL    int
+	Origin string
 }
 
-type Get struct {
-	Name string
+// GetHash requests a file by the hex encoded SHA-256 of its content, so
+// the request keeps working when the file is renamed. Offset, Length, TTL
+// and Origin work like in Get.
+type GetHash struct {
+	ID     string
+	Hash   string
+	Offset int64
+	Length int64
+	TTL    int
+	Origin string
 }
 
+// File announces that the sender has the requested file. ID echoes the
+// ID of the Get it answers, Size lets the requester split the file into
+// chunks before fetching it, Hash is the hex encoded SHA-256 of the content
+// the downloaded file is checked against and Root is the Merkle root every
+// chunk is verified against while it arrives. Addr is the TCP address of
+// the node that has the file when the reply was relayed back along the
+// path of a forwarded request, empty when the sender has it. Load is the
+// number of uploads the node is serving, which the requester ranks it by.
+// Name is the file name in replies to a GetHash, so the requester saves the
+// content under it, escaped like a Match's.
 type File struct {
+	ID      string
 	Method  int
 	TCPPort int
+	Size    int64
+	Hash    string
+	Root    string
+	Addr    string
+	Load    int
+	Name    string
+}
+
+// Search asks peers for files whose name matches Query, either a glob
+// pattern or a substring
+type Search struct {
+	ID    string
+	Query string
+}
+
+// Match is a file listed in a Result
+type Match struct {
+	Name string
+	Size int64
+	Hash string
+}
+
+// Result answers a Search with the matching files of the sender
+type Result struct {
+	ID      string
+	Matches []Match
+}
+
+// List asks a peer for one page of its shared files
+type List struct {
+	ID   string
+	Page int
+}
+
+// Catalog answers a List with a page of the sender's shared files. Pages
+// is the total number of pages, so the requester knows when to stop.
+type Catalog struct {
+	ID      string
+	Page    int
+	Pages   int
+	Matches []Match
+}
+
+// Ping checks that a peer is alive. Timestamp is the send time in Unix
+// nanoseconds, echoed back in the Pong so the sender can measure the round
+// trip without keeping state. Updates piggyback membership changes.
+type Ping struct {
+	Nonce     string
+	Timestamp int64
+	Updates   []Update
+}
+
+// Pong answers a Ping with its nonce and timestamp
+type Pong struct {
+	Nonce     string
+	Timestamp int64
+	Updates   []Update
+}
+
+// PingReq asks a member to ping Target on our behalf and relay the Pong,
+// so a lossy link between us and Target alone doesn't get it suspected
+type PingReq struct {
+	Nonce     string
+	Timestamp int64
+	Target    string
+	Updates   []Update
+}
+
+// Sync carries (part of) the full membership state of a node. Reply asks
+// the receiver to send its own state back. From is the node ID of the
+// sender, which lets the receiver tell which member the datagram's source
+// address belongs to.
+type Sync struct {
+	Reply   bool
+	From    string
+	Updates []Update
+}
+
+// Beacon announces a node on the local network. It is sent from the node's
+// UDP socket, so the source address is where the node can be reached.
+type Beacon struct {
+	ID string
+}
+
+// Contact is a DHT node, its ID and UDP address, encoded as id@addr
+type Contact struct {
+	ID   string
+	Addr string
+}
+
+// Lookup asks a DHT node for the providers it stores under Key and the
+// nodes it knows closest to Key. From is the sender's node ID.
+type Lookup struct {
+	Nonce string
+	From  string
+	Key   string
+}
+
+// Found answers a Lookup. Providers are the UDP addresses of nodes holding
+// the content, separated by semicolons on the wire, as are the Contacts.
+type Found struct {
+	Nonce     string
+	From      string
+	Providers []string
+	Contacts  []Contact
+}
+
+// Provide stores a provider record for Key at a DHT node. The provider is
+// the sender, at the source address of the datagram.
+type Provide struct {
+	From string
+	Key  string
 }
 
-func (d *Discover) Marshal() string {
-	list := strings.Join(d.List, ",")
-	return fmt.Sprintf("%s,%s\n", config.MsgDiscover, list)
+// Summary carries a Bloom filter of the names and content hashes of the
+// files the sender shares, so requests for files it can't have skip it
+type Summary struct {
+	From   string
+	Filter string
+}
+
+// State is the state of a cluster member as gossiped
+type State byte
+
+const (
+	Alive   State = 'a'
+	Suspect State = 's'
+	Dead    State = 'd'
+)
+
+// Capability flags advertise the optional protocol features a node speaks
+type Capability uint
+
+const (
+	CapSearch  Capability = 1 << iota // Search and Result
+	CapBrowse                         // List and Catalog
+	CapContent                        // GetHash and content links
+	CapSwarm                          // Ranged, Merkle verified transfers
+)
+
+// Meta is what a node advertises about itself. It only changes together
+// with the node's incarnation.
+type Meta struct {
+	ID           string
+	TCPPort      int
+	Version      int
+	Capabilities Capability
+}
+
+// Update is the gossiped state of a member. A higher Incarnation overrides
+// a lower one, only the member itself raises it to refute a suspicion.
+// Alive updates carry the member's Meta.
+type Update struct {
+	State       State
+	Incarnation uint64
+	Addr        string
+	Meta
+}
+
+// NewID returns a random request ID used to correlate requests and replies
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 func (g *Get) Marshal() string {
-	return fmt.Sprintf("%s,%s\n", config.MsgGet, g.Name)
+	return fmt.Sprintf("%s,%s,%s%s\n", config.MsgGet, g.ID, url.QueryEscape(g.Name), marshalRequest(g.Offset, g.Length, g.TTL, g.Origin))
+}
+
+func (g *GetHash) Marshal() string {
+	return fmt.Sprintf("%s,%s,%s%s\n", config.MsgGetHash, g.ID, g.Hash, marshalRequest(g.Offset, g.Length, g.TTL, g.Origin))
+}
+
+// marshalRequest encodes the optional fields of Get and GetHash, leaving
+// out the trailing ones that are zero
+func marshalRequest(offset, length int64, ttl int, origin string) string {
+	switch {
+	case ttl != 0 || origin != "":
+		return fmt.Sprintf(",%d,%d,%d,%s", offset, length, ttl, origin)
+	case offset != 0 || length != 0:
+		return fmt.Sprintf(",%d,%d", offset, length)
+	default:
+		return ""
+	}
 }
 
 func (f *File) Marshal() string {
-	return fmt.Sprintf("%s,%d,%d\n", config.MsgFile, f.Method, f.TCPPort)
+	switch {
+	case f.Name != "":
+		return fmt.Sprintf("%s,%s,%d,%d,%d,%s,%s,%s,%d,%s\n",
+			config.MsgFile, f.ID, f.Method, f.TCPPort, f.Size, f.Hash, f.Root, f.Addr, f.Load, url.QueryEscape(f.Name))
+	case f.Addr != "" || f.Load != 0:
+		return fmt.Sprintf("%s,%s,%d,%d,%d,%s,%s,%s,%d\n",
+			config.MsgFile, f.ID, f.Method, f.TCPPort, f.Size, f.Hash, f.Root, f.Addr, f.Load)
+	default:
+		return fmt.Sprintf("%s,%s,%d,%d,%d,%s,%s\n", config.MsgFile, f.ID, f.Method, f.TCPPort, f.Size, f.Hash, f.Root)
+	}
+}
+
+func (s *Search) Marshal() string {
+	return fmt.Sprintf("%s,%s,%s\n", config.MsgSearch, s.ID, s.Query)
+}
+
+func (r *Result) Marshal() string {
+	fields := []string{config.MsgResult, r.ID}
+	for _, m := range r.Matches {
+		fields = append(fields, m.marshal())
+	}
+	return strings.Join(fields, ",") + "\n"
+}
+
+func (l *List) Marshal() string {
+	return fmt.Sprintf("%s,%s,%d\n", config.MsgList, l.ID, l.Page)
+}
+
+func (c *Catalog) Marshal() string {
+	fields := []string{config.MsgCatalog, c.ID, strconv.Itoa(c.Page), strconv.Itoa(c.Pages)}
+	for _, m := range c.Matches {
+		fields = append(fields, m.marshal())
+	}
+	return strings.Join(fields, ",") + "\n"
+}
+
+func (p *Ping) Marshal() string {
+	return fmt.Sprintf("%s,%s,%d%s\n", config.MsgPing, p.Nonce, p.Timestamp, marshalUpdates(p.Updates))
+}
+
+func (p *Pong) Marshal() string {
+	return fmt.Sprintf("%s,%s,%d%s\n", config.MsgPong, p.Nonce, p.Timestamp, marshalUpdates(p.Updates))
+}
+
+func (p *PingReq) Marshal() string {
+	return fmt.Sprintf("%s,%s,%d,%s%s\n", config.MsgPingReq, p.Nonce, p.Timestamp, p.Target, marshalUpdates(p.Updates))
+}
+
+func (s *Sync) Marshal() string {
+	reply := 0
+	if s.Reply {
+		reply = 1
+	}
+	return fmt.Sprintf("%s,%d,%s%s\n", config.MsgSync, reply, s.From, marshalUpdates(s.Updates))
+}
+
+func (b *Beacon) Marshal() string {
+	return fmt.Sprintf("%s,%s\n", config.MsgBeacon, b.ID)
+}
+
+func (l *Lookup) Marshal() string {
+	return fmt.Sprintf("%s,%s,%s,%s\n", config.MsgLookup, l.Nonce, l.From, l.Key)
+}
+
+func (f *Found) Marshal() string {
+	contacts := make([]string, 0, len(f.Contacts))
+	for _, c := range f.Contacts {
+		contacts = append(contacts, c.ID+"@"+c.Addr)
+	}
+	return fmt.Sprintf("%s,%s,%s,%s,%s\n", config.MsgFound, f.Nonce, f.From,
+		strings.Join(f.Providers, ";"), strings.Join(contacts, ";"))
+}
+
+func (s *Summary) Marshal() string {
+	return fmt.Sprintf("%s,%s,%s\n", config.MsgSummary, s.From, s.Filter)
+}
+
+func (p *Provide) Marshal() string {
+	return fmt.Sprintf("%s,%s,%s\n", config.MsgProvide, p.From, p.Key)
+}
+
+// String encodes an update as its state, incarnation and address, followed
+// by the meta of an alive member, e.g. a42@127.0.0.1:1378;9f86d0;33680;1;f,
+// or just the ID of a suspect or dead one, e.g. s42@127.0.0.1:1378;9f86d0
+func (u Update) String() string {
+	switch {
+	case u.Meta == (Meta{}):
+		return fmt.Sprintf("%c%d@%s", u.State, u.Incarnation, u.Addr)
+	case u.Meta == (Meta{ID: u.ID}):
+		return fmt.Sprintf("%c%d@%s;%s", u.State, u.Incarnation, u.Addr, u.ID)
+	}
+	return fmt.Sprintf("%c%d@%s;%s;%d;%d;%x", u.State, u.Incarnation, u.Addr,
+		u.ID, u.TCPPort, u.Version, uint(u.Capabilities))
+}
+
+// ParseUpdate decodes an update encoded by Update.String
+func ParseUpdate(s string) (Update, error) {
+	incarnation, rest, ok := strings.Cut(s, "@")
+	if !ok || len(incarnation) < 2 || rest == "" {
+		return Update{}, fmt.Errorf("%w: %q", ErrInvalidUpdate, s)
+	}
+
+	fields := strings.Split(rest, ";")
+	addr := fields[0]
+	if addr == "" || len(fields) == 3 || len(fields) == 4 || len(fields) > 5 {
+		return Update{}, fmt.Errorf("%w: %q", ErrInvalidUpdate, s)
+	}
+
+	state := State(incarnation[0])
+	if state != Alive && state != Suspect && state != Dead {
+		return Update{}, fmt.Errorf("%w: state %q", ErrInvalidUpdate, incarnation[0])
+	}
+
+	n, err := strconv.ParseUint(incarnation[1:], 10, 64)
+	if err != nil {
+		return Update{}, fmt.Errorf("%w: incarnation %q", ErrInvalidUpdate, incarnation[1:])
+	}
+
+	u := Update{State: state, Incarnation: n, Addr: addr}
+	switch len(fields) {
+	case 2:
+		u.ID = fields[1]
+	case 5:
+		if u.Meta, err = parseMeta(fields[1:]); err != nil {
+			return Update{}, err
+		}
+	}
+	return u, nil
+}
+
+func parseMeta(fields []string) (Meta, error) {
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port < 0 || port > 65535 {
+		return Meta{}, fmt.Errorf("%w: TCP port %q", ErrInvalidUpdate, fields[1])
+	}
+
+	version, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Meta{}, fmt.Errorf("%w: version %q", ErrInvalidUpdate, fields[2])
+	}
+
+	capabilities, err := strconv.ParseUint(fields[3], 16, 32)
+	if err != nil {
+		return Meta{}, fmt.Errorf("%w: capabilities %q", ErrInvalidUpdate, fields[3])
+	}
+
+	return Meta{ID: fields[0], TCPPort: port, Version: version, Capabilities: Capability(capabilities)}, nil
+}
+
+// SplitUpdates splits updates into chunks whose encoded size stays within
+// size bytes, so a large membership state can be sent in several
+// datagrams. There is always at least one, possibly empty, chunk.
+func SplitUpdates(updates []Update, size int) [][]Update {
+	chunks := [][]Update{{}}
+	used := 0
+
+	for _, u := range updates {
+		length := len(u.String()) + 1 // separating comma
+		last := len(chunks) - 1
+
+		if used+length > size && len(chunks[last]) > 0 {
+			chunks = append(chunks, []Update{})
+			last++
+			used = 0
+		}
+
+		chunks[last] = append(chunks[last], u)
+		used += length
+	}
+
+	return chunks
+}
+
+// marshalUpdates encodes updates as trailing comma separated fields
+func marshalUpdates(updates []Update) string {
+	var b strings.Builder
+	for _, u := range updates {
+		b.WriteByte(',')
+		b.WriteString(u.String())
+	}
+	return b.String()
+}
+
+func unmarshalUpdates(parts []string) ([]Update, error) {
+	updates := make([]Update, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		u, err := ParseUpdate(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// Paginate splits matches into pages whose encoded size stays within size
+// bytes. There is always at least one, possibly empty, page.
+func Paginate(matches []Match, size int) [][]Match {
+	pages := [][]Match{{}}
+	used := 0
+
+	for _, m := range matches {
+		length := len(m.marshal()) + 1 // separating comma
+		last := len(pages) - 1
+
+		if used+length > size && len(pages[last]) > 0 {
+			pages = append(pages, []Match{})
+			last++
+			used = 0
+		}
+
+		pages[last] = append(pages[last], m)
+		used += length
+	}
+
+	return pages
+}
+
+// marshal encodes a match as name:size:hash with the name escaped, so it
+// can't break the comma separated list it is part of
+func (m Match) marshal() string {
+	return fmt.Sprintf("%s:%d:%s", url.QueryEscape(m.Name), m.Size, m.Hash)
+}
+
+func unmarshalMatch(s string) (Match, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return Match{}, fmt.Errorf("%w: match %q", ErrMalformedMessage, s)
+	}
+
+	name, err := url.QueryUnescape(fields[0])
+	if err != nil {
+		return Match{}, fmt.Errorf("%w: match name %q", ErrMalformedMessage, fields[0])
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return Match{}, fmt.Errorf("%w: match size %q", ErrMalformedMessage, fields[1])
+	}
+
+	return Match{Name: name, Size: size, Hash: fields[2]}, nil
+}
+
+func unmarshalMatches(parts []string) ([]Match, error) {
+	matches := make([]Match, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		m, err := unmarshalMatch(part)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+
+	return matches, nil
 }
 
 // Unmarshal parses a message string into a Message type
@@ -62,36 +534,253 @@ func Unmarshal(s string) (Message, error) {
 	}
 
 	switch parts[0] {
-	case config.MsgDiscover:
-		if len(parts) < 2 {
-			return &Discover{List: []string{}}, nil
+	case config.MsgGet:
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("%w: Get message requires request ID and file name", ErrMalformedMessage)
 		}
-		return &Discover{List: parts[1:]}, nil
 
-	case config.MsgGet:
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("%w: Get message requires file name", ErrMalformedMessage)
+		name, err := url.QueryUnescape(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("%w: name %q", ErrMalformedMessage, parts[2])
+		}
+		offset, length, err := parseRange(parts[3:])
+		if err != nil {
+			return nil, err
+		}
+		ttl, origin, err := parseRoute(parts[3:])
+		if err != nil {
+			return nil, err
 		}
-		return &Get{Name: parts[1]}, nil
+		return &Get{ID: parts[1], Name: name, Offset: offset, Length: length, TTL: ttl, Origin: origin}, nil
 
-	case config.MsgFile:
+	case config.MsgGetHash:
 		if len(parts) < 3 {
-			return nil, fmt.Errorf("%w: File message requires method and port", ErrMalformedMessage)
+			return nil, fmt.Errorf("%w: GetHash message requires request ID and hash", ErrMalformedMessage)
+		}
+
+		offset, length, err := parseRange(parts[3:])
+		if err != nil {
+			return nil, err
+		}
+		ttl, origin, err := parseRoute(parts[3:])
+		if err != nil {
+			return nil, err
+		}
+		return &GetHash{ID: parts[1], Hash: parts[2], Offset: offset, Length: length, TTL: ttl, Origin: origin}, nil
+
+	case config.MsgFile:
+		if len(parts) < 7 {
+			return nil, fmt.Errorf("%w: File message requires request ID, method, port, size, hash and root", ErrMalformedMessage)
 		}
 
-		method, err := strconv.Atoi(parts[1])
+		method, err := strconv.Atoi(parts[2])
 		if err != nil {
 			return nil, fmt.Errorf("%w: %v", ErrInvalidMethod, err)
 		}
 
-		port, err := strconv.Atoi(parts[2])
+		port, err := strconv.Atoi(parts[3])
 		if err != nil {
 			return nil, fmt.Errorf("%w: %v", ErrInvalidPort, err)
 		}
 
-		return &File{Method: method, TCPPort: port}, nil
+		size, err := strconv.ParseInt(parts[4], 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: size %q", ErrInvalidRange, parts[4])
+		}
+
+		file := &File{ID: parts[1], Method: method, TCPPort: port, Size: size, Hash: parts[5], Root: parts[6]}
+		if len(parts) > 8 {
+			file.Addr = parts[7]
+			if file.Load, err = strconv.Atoi(parts[8]); err != nil || file.Load < 0 {
+				return nil, fmt.Errorf("%w: load %q", ErrMalformedMessage, parts[8])
+			}
+		}
+		if len(parts) > 9 {
+			if file.Name, err = url.QueryUnescape(parts[9]); err != nil {
+				return nil, fmt.Errorf("%w: name %q", ErrMalformedMessage, parts[9])
+			}
+		}
+		return file, nil
+
+	case config.MsgSearch:
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("%w: Search message requires request ID and query", ErrMalformedMessage)
+		}
+		// The query is the rest of the message, commas included
+		return &Search{ID: parts[1], Query: strings.Join(parts[2:], ",")}, nil
+
+	case config.MsgResult:
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("%w: Result message requires request ID", ErrMalformedMessage)
+		}
+
+		matches, err := unmarshalMatches(parts[2:])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Result{ID: parts[1], Matches: matches}, nil
+
+	case config.MsgList:
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("%w: List message requires request ID and page", ErrMalformedMessage)
+		}
+
+		page, err := strconv.Atoi(parts[2])
+		if err != nil || page < 0 {
+			return nil, fmt.Errorf("%w: page %q", ErrMalformedMessage, parts[2])
+		}
+
+		return &List{ID: parts[1], Page: page}, nil
+
+	case config.MsgCatalog:
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("%w: Catalog message requires request ID, page and pages", ErrMalformedMessage)
+		}
+
+		page, err := strconv.Atoi(parts[2])
+		if err != nil || page < 0 {
+			return nil, fmt.Errorf("%w: page %q", ErrMalformedMessage, parts[2])
+		}
+
+		pages, err := strconv.Atoi(parts[3])
+		if err != nil || pages < 1 {
+			return nil, fmt.Errorf("%w: pages %q", ErrMalformedMessage, parts[3])
+		}
+
+		matches, err := unmarshalMatches(parts[4:])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Catalog{ID: parts[1], Page: page, Pages: pages, Matches: matches}, nil
+
+	case config.MsgPing, config.MsgPong:
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("%w: %s message requires nonce and timestamp", ErrMalformedMessage, parts[0])
+		}
+
+		timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: timestamp %q", ErrMalformedMessage, parts[2])
+		}
+
+		updates, err := unmarshalUpdates(parts[3:])
+		if err != nil {
+			return nil, err
+		}
+
+		if parts[0] == config.MsgPing {
+			return &Ping{Nonce: parts[1], Timestamp: timestamp, Updates: updates}, nil
+		}
+		return &Pong{Nonce: parts[1], Timestamp: timestamp, Updates: updates}, nil
+
+	case config.MsgPingReq:
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("%w: PingReq message requires nonce, timestamp and target", ErrMalformedMessage)
+		}
+
+		timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: timestamp %q", ErrMalformedMessage, parts[2])
+		}
+
+		updates, err := unmarshalUpdates(parts[4:])
+		if err != nil {
+			return nil, err
+		}
+		return &PingReq{Nonce: parts[1], Timestamp: timestamp, Target: parts[3], Updates: updates}, nil
+
+	case config.MsgSync:
+		if len(parts) < 3 || (parts[1] != "0" && parts[1] != "1") {
+			return nil, fmt.Errorf("%w: Sync message requires a reply flag and sender", ErrMalformedMessage)
+		}
+
+		updates, err := unmarshalUpdates(parts[3:])
+		if err != nil {
+			return nil, err
+		}
+		return &Sync{Reply: parts[1] == "1", From: parts[2], Updates: updates}, nil
+
+	case config.MsgBeacon:
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("%w: Beacon message requires a node ID", ErrMalformedMessage)
+		}
+		return &Beacon{ID: parts[1]}, nil
+
+	case config.MsgLookup:
+		if len(parts) != 4 || parts[3] == "" {
+			return nil, fmt.Errorf("%w: Lookup message requires nonce, sender and key", ErrMalformedMessage)
+		}
+		return &Lookup{Nonce: parts[1], From: parts[2], Key: parts[3]}, nil
+
+	case config.MsgFound:
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("%w: Found message requires nonce, sender, providers and contacts", ErrMalformedMessage)
+		}
+
+		found := &Found{Nonce: parts[1], From: parts[2]}
+		if parts[3] != "" {
+			found.Providers = strings.Split(parts[3], ";")
+		}
+		if parts[4] != "" {
+			for _, c := range strings.Split(parts[4], ";") {
+				id, addr, ok := strings.Cut(c, "@")
+				if !ok || id == "" || addr == "" {
+					return nil, fmt.Errorf("%w: contact %q", ErrMalformedMessage, c)
+				}
+				found.Contacts = append(found.Contacts, Contact{ID: id, Addr: addr})
+			}
+		}
+		return found, nil
+
+	case config.MsgProvide:
+		if len(parts) != 3 || parts[2] == "" {
+			return nil, fmt.Errorf("%w: Provide message requires sender and key", ErrMalformedMessage)
+		}
+		return &Provide{From: parts[1], Key: parts[2]}, nil
+
+	case config.MsgSummary:
+		if len(parts) != 3 || parts[2] == "" {
+			return nil, fmt.Errorf("%w: Summary message requires sender and filter", ErrMalformedMessage)
+		}
+		return &Summary{From: parts[1], Filter: parts[2]}, nil
 
 	default:
 		return nil, fmt.Errorf("%w: %s", ErrUnknownMessage, parts[0])
 	}
 }
+
+// parseRoute parses the TTL and origin that follow the byte range of a
+// forwarded Get or GetHash
+func parseRoute(parts []string) (int, string, error) {
+	if len(parts) < 4 {
+		return 0, "", nil
+	}
+
+	ttl, err := strconv.Atoi(parts[2])
+	if err != nil || ttl < 0 {
+		return 0, "", fmt.Errorf("%w: TTL %q", ErrMalformedMessage, parts[2])
+	}
+
+	return ttl, parts[3], nil
+}
+
+// parseRange parses the optional offset and length fields of a request
+func parseRange(parts []string) (int64, int64, error) {
+	if len(parts) < 2 {
+		return 0, 0, nil
+	}
+
+	offset, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("%w: offset %q", ErrInvalidRange, parts[0])
+	}
+
+	length, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || length < 0 {
+		return 0, 0, fmt.Errorf("%w: length %q", ErrInvalidRange, parts[1])
+	}
+
+	return offset, length, nil
+}
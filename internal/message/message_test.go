@@ -1,35 +1,35 @@
 package message
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
-func TestDiscoverMarshal(t *testing.T) {
+func TestSyncMarshal(t *testing.T) {
 	tests := []struct {
 		name     string
-		discover *Discover
+		sync     *Sync
 		expected string
 	}{
 		{
-			name:     "empty list",
-			discover: &Discover{List: []string{}},
-			expected: "DISCOVER,\n",
+			name:     "empty state",
+			sync:     &Sync{},
+			expected: "Sync,0,\n",
 		},
 		{
-			name:     "single address",
-			discover: &Discover{List: []string{"127.0.0.1:1378"}},
-			expected: "DISCOVER,127.0.0.1:1378\n",
-		},
-		{
-			name:     "multiple addresses",
-			discover: &Discover{List: []string{"127.0.0.1:1378", "192.168.1.1:1379"}},
-			expected: "DISCOVER,127.0.0.1:1378,192.168.1.1:1379\n",
+			name: "state asking for a reply",
+			sync: &Sync{Reply: true, From: "00ff", Updates: []Update{
+				{State: Alive, Incarnation: 42, Addr: "127.0.0.1:1378"},
+				{State: Dead, Incarnation: 7, Addr: "10.0.0.1:1380"},
+			}},
+			expected: "Sync,1,00ff,a42@127.0.0.1:1378,d7@10.0.0.1:1380\n",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := tt.discover.Marshal()
+			result := tt.sync.Marshal()
 			if result != tt.expected {
 				t.Errorf("Marshal() = %q, want %q", result, tt.expected)
 			}
@@ -37,9 +37,100 @@ func TestDiscoverMarshal(t *testing.T) {
 	}
 }
 
+func TestFoundRoundTrip(t *testing.T) {
+	tests := []*Found{
+		{Nonce: "a1b2", From: "00ff"},
+		{
+			Nonce:     "a1b2",
+			From:      "00ff",
+			Providers: []string{"10.0.0.1:1378", "10.0.0.2:1378"},
+			Contacts:  []Contact{{ID: "0011", Addr: "10.0.0.3:1378"}, {ID: "0022", Addr: "[::1]:1378"}},
+		},
+	}
+
+	for _, found := range tests {
+		msg, err := Unmarshal(strings.TrimSpace(found.Marshal()))
+		if err != nil {
+			t.Fatalf("Unmarshal(%q) error = %v", found.Marshal(), err)
+		}
+		if got, ok := msg.(*Found); !ok || fmt.Sprint(got) != fmt.Sprint(found) {
+			t.Errorf("Unmarshal(%q) = %#v, want %#v", found.Marshal(), msg, found)
+		}
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    Update
+		expectError bool
+	}{
+		{input: "a42@127.0.0.1:1378", expected: Update{State: Alive, Incarnation: 42, Addr: "127.0.0.1:1378"}},
+		{input: "s0@node2:1378", expected: Update{State: Suspect, Incarnation: 0, Addr: "node2:1378"}},
+		{
+			input: "a42@127.0.0.1:1378;9f86d0;33680;1;f",
+			expected: Update{State: Alive, Incarnation: 42, Addr: "127.0.0.1:1378", Meta: Meta{
+				ID: "9f86d0", TCPPort: 33680, Version: 1, Capabilities: CapSearch | CapBrowse | CapContent | CapSwarm,
+			}},
+		},
+		{input: "d7@10.0.0.1:1380;9f86d0", expected: Update{State: Dead, Incarnation: 7, Addr: "10.0.0.1:1380", Meta: Meta{ID: "9f86d0"}}},
+		{input: "a42@127.0.0.1:1378;9f86d0;33680", expectError: true},
+		{input: "a42@127.0.0.1:1378;9f86d0;port;1;f", expectError: true},
+		{input: "x1@127.0.0.1:1378", expectError: true},
+		{input: "a@127.0.0.1:1378", expectError: true},
+		{input: "a1", expectError: true},
+		{input: "a-1@127.0.0.1:1378", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseUpdate(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ParseUpdate() expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseUpdate() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ParseUpdate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitUpdates(t *testing.T) {
+	updates := make([]Update, 100)
+	for i := range updates {
+		updates[i] = Update{State: Alive, Incarnation: 1700000000, Addr: fmt.Sprintf("10.0.0.%d:1378", i)}
+	}
+
+	chunks := SplitUpdates(updates, 256)
+	if len(chunks) < 2 {
+		t.Fatalf("SplitUpdates() = %d chunk(s), want several", len(chunks))
+	}
+
+	total := 0
+	for _, chunk := range chunks {
+		if size := len(marshalUpdates(chunk)); size > 256 {
+			t.Errorf("chunk of %d bytes exceeds 256", size)
+		}
+		total += len(chunk)
+	}
+	if total != len(updates) {
+		t.Errorf("SplitUpdates() kept %d update(s), want %d", total, len(updates))
+	}
+
+	if chunks := SplitUpdates(nil, 256); len(chunks) != 1 || len(chunks[0]) != 0 {
+		t.Errorf("SplitUpdates(nil) = %v, want a single empty chunk", chunks)
+	}
+}
+
 func TestGetMarshal(t *testing.T) {
-	get := &Get{Name: "test.pdf"}
-	expected := "Get,test.pdf\n"
+	get := &Get{ID: "a1b2", Name: "test.pdf"}
+	expected := "Get,a1b2,test.pdf\n"
 
 	result := get.Marshal()
 	if result != expected {
@@ -47,9 +138,47 @@ func TestGetMarshal(t *testing.T) {
 	}
 }
 
+func TestGetMarshalRange(t *testing.T) {
+	get := &Get{ID: "a1b2", Name: "test.pdf", Offset: 1024, Length: 4096}
+	expected := "Get,a1b2,test.pdf,1024,4096\n"
+
+	result := get.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+}
+
+func TestGetHashMarshal(t *testing.T) {
+	getHash := &GetHash{ID: "a1b2", Hash: "9f86d0"}
+	expected := "GetHash,a1b2,9f86d0\n"
+
+	result := getHash.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+
+	getHash.Offset = 1024
+	expected = "GetHash,a1b2,9f86d0,1024,0\n"
+
+	result = getHash.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() with range = %q, want %q", result, expected)
+	}
+
+	getHash.Offset = 0
+	getHash.TTL = 2
+	getHash.Origin = "127.0.0.1:1378"
+	expected = "GetHash,a1b2,9f86d0,0,0,2,127.0.0.1:1378\n"
+
+	result = getHash.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() forwarded = %q, want %q", result, expected)
+	}
+}
+
 func TestFileMarshal(t *testing.T) {
-	file := &File{Method: 1, TCPPort: 33680}
-	expected := "File,1,33680\n"
+	file := &File{ID: "a1b2", Method: 1, TCPPort: 33680, Size: 4096, Hash: "9f86d0", Root: "e3b0c4"}
+	expected := "File,a1b2,1,33680,4096,9f86d0,e3b0c4\n"
 
 	result := file.Marshal()
 	if result != expected {
@@ -57,6 +186,123 @@ func TestFileMarshal(t *testing.T) {
 	}
 }
 
+func TestSearchMarshal(t *testing.T) {
+	search := &Search{ID: "a1b2", Query: "*.pdf"}
+	expected := "Search,a1b2,*.pdf\n"
+
+	result := search.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+}
+
+func TestResultMarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *Result
+		expected string
+	}{
+		{
+			name:     "no matches",
+			result:   &Result{ID: "a1b2"},
+			expected: "Result,a1b2\n",
+		},
+		{
+			name: "escaped names",
+			result: &Result{ID: "a1b2", Matches: []Match{
+				{Name: "a,b:c.txt", Size: 12, Hash: "9f86d0"},
+				{Name: "notes.txt", Size: 3, Hash: "e3b0c4"},
+			}},
+			expected: "Result,a1b2,a%2Cb%3Ac.txt:12:9f86d0,notes.txt:3:e3b0c4\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result.Marshal()
+			if result != tt.expected {
+				t.Errorf("Marshal() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestListMarshal(t *testing.T) {
+	list := &List{ID: "a1b2", Page: 2}
+	expected := "List,a1b2,2\n"
+
+	result := list.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+}
+
+func TestCatalogMarshal(t *testing.T) {
+	catalog := &Catalog{ID: "a1b2", Page: 0, Pages: 3, Matches: []Match{
+		{Name: "notes.txt", Size: 3, Hash: "e3b0c4"},
+	}}
+	expected := "Catalog,a1b2,0,3,notes.txt:3:e3b0c4\n"
+
+	result := catalog.Marshal()
+	if result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	matches := make([]Match, 10)
+	for i := range matches {
+		matches[i] = Match{Name: fmt.Sprintf("file-%d.txt", i), Size: 100, Hash: strings.Repeat("a", 64)}
+	}
+	// Encoded size of a single match including its separator
+	perMatch := len(matches[0].marshal()) + 1
+
+	tests := []struct {
+		name     string
+		matches  []Match
+		size     int
+		expected int
+	}{
+		{name: "no matches", matches: nil, size: 1000, expected: 1},
+		{name: "everything fits", matches: matches, size: 10 * perMatch, expected: 1},
+		{name: "three per page", matches: matches, size: 3*perMatch + 1, expected: 4},
+		{name: "oversized match gets its own page", matches: matches[:2], size: 10, expected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := Paginate(tt.matches, tt.size)
+			if len(pages) != tt.expected {
+				t.Fatalf("Paginate() returned %d pages, want %d", len(pages), tt.expected)
+			}
+
+			total := 0
+			for _, page := range pages {
+				total += len(page)
+			}
+			if total != len(tt.matches) {
+				t.Errorf("Paginate() kept %d matches, want %d", total, len(tt.matches))
+			}
+		})
+	}
+}
+
+func TestPingPongMarshal(t *testing.T) {
+	ping := &Ping{Nonce: "a1b2", Timestamp: 1700000000000000000}
+	expected := "Ping,a1b2,1700000000000000000\n"
+
+	if result := ping.Marshal(); result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+
+	pong := &Pong{Nonce: "a1b2", Timestamp: 1700000000000000000}
+	expected = "Pong,a1b2,1700000000000000000\n"
+
+	if result := pong.Marshal(); result != expected {
+		t.Errorf("Marshal() = %q, want %q", result, expected)
+	}
+}
+
 func TestUnmarshal(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -65,23 +311,161 @@ func TestUnmarshal(t *testing.T) {
 		expectError bool
 	}{
 		{
-			name:        "discover message",
-			input:       "DISCOVER,127.0.0.1:1378,192.168.1.1:1379",
-			expectType:  "Discover",
+			name:        "sync message",
+			input:       "Sync,1,00ff,a42@127.0.0.1:1378,s3@192.168.1.1:1379",
+			expectType:  "Sync",
 			expectError: false,
 		},
+		{
+			name:        "sync message without reply flag",
+			input:       "Sync",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "sync message without sender",
+			input:       "Sync,0",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "beacon message",
+			input:       "Beacon,00ff",
+			expectType:  "Beacon",
+			expectError: false,
+		},
+		{
+			name:        "lookup message",
+			input:       "Lookup,a1b2,00ff,0011",
+			expectType:  "Lookup",
+			expectError: false,
+		},
+		{
+			name:        "lookup message without key",
+			input:       "Lookup,a1b2,00ff",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "found message with a bad contact",
+			input:       "Found,a1b2,00ff,,10.0.0.1:1378",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "provide message",
+			input:       "Provide,00ff,0011",
+			expectType:  "Provide",
+			expectError: false,
+		},
+		{
+			name:        "summary message",
+			input:       "Summary,00ff,7:AAAA",
+			expectType:  "Summary",
+			expectError: false,
+		},
+		{
+			name:        "summary message without filter",
+			input:       "Summary,00ff,",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "beacon message without ID",
+			input:       "Beacon,",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "ping request message",
+			input:       "PingReq,a1b2,1700000000000000000,10.0.0.1:1380",
+			expectType:  "PingReq",
+			expectError: false,
+		},
+		{
+			name:        "ping message with a malformed update",
+			input:       "Ping,a1b2,1700000000000000000,z9@10.0.0.1:1380",
+			expectType:  "",
+			expectError: true,
+		},
 		{
 			name:        "get message",
-			input:       "Get,resume.pdf",
+			input:       "Get,a1b2,resume.pdf",
 			expectType:  "Get",
 			expectError: false,
 		},
 		{
 			name:        "file message",
-			input:       "File,1,33680",
+			input:       "File,a1b2,1,33680,4096,9f86d0,e3b0c4",
 			expectType:  "File",
 			expectError: false,
 		},
+		{
+			name:        "get hash message",
+			input:       "GetHash,a1b2,9f86d0",
+			expectType:  "GetHash",
+			expectError: false,
+		},
+		{
+			name:        "malformed get hash message",
+			input:       "GetHash,a1b2",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "search message",
+			input:       "Search,a1b2,report",
+			expectType:  "Search",
+			expectError: false,
+		},
+		{
+			name:        "result message",
+			input:       "Result,a1b2,notes.txt:3:e3b0c4",
+			expectType:  "Result",
+			expectError: false,
+		},
+		{
+			name:        "malformed result match",
+			input:       "Result,a1b2,notes.txt:3",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "list message",
+			input:       "List,a1b2,0",
+			expectType:  "List",
+			expectError: false,
+		},
+		{
+			name:        "catalog message",
+			input:       "Catalog,a1b2,0,1,notes.txt:3:e3b0c4",
+			expectType:  "Catalog",
+			expectError: false,
+		},
+		{
+			name:        "catalog message without pages",
+			input:       "Catalog,a1b2,0,0",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "ping message",
+			input:       "Ping,a1b2,1700000000000000000",
+			expectType:  "Ping",
+			expectError: false,
+		},
+		{
+			name:        "pong message",
+			input:       "Pong,a1b2,1700000000000000000",
+			expectType:  "Pong",
+			expectError: false,
+		},
+		{
+			name:        "ping message with invalid timestamp",
+			input:       "Ping,a1b2,yesterday",
+			expectType:  "",
+			expectError: true,
+		},
 		{
 			name:        "empty message",
 			input:       "",
@@ -100,9 +484,39 @@ func TestUnmarshal(t *testing.T) {
 			expectType:  "",
 			expectError: true,
 		},
+		{
+			name:        "malformed get message without ID",
+			input:       "Get,resume.pdf",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "get message with negative offset",
+			input:       "Get,a1b2,resume.pdf,-1,0",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "get message with invalid length",
+			input:       "Get,a1b2,resume.pdf,0,abc",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "get message with invalid TTL",
+			input:       "Get,a1b2,resume.pdf,0,0,-1,127.0.0.1:1378",
+			expectType:  "",
+			expectError: true,
+		},
+		{
+			name:        "file message with invalid load",
+			input:       "File,a1b2,1,33680,4096,9f86d0,e3b0c4,,busy",
+			expectType:  "",
+			expectError: true,
+		},
 		{
 			name:        "malformed file message",
-			input:       "File,1",
+			input:       "File,a1b2,1,33680,4096,9f86d0",
 			expectType:  "",
 			expectError: true,
 		},
@@ -125,14 +539,62 @@ func TestUnmarshal(t *testing.T) {
 			}
 
 			switch tt.expectType {
-			case "Discover":
-				if _, ok := result.(*Discover); !ok {
-					t.Errorf("Unmarshal() expected *Discover, got %T", result)
+			case "Sync":
+				if _, ok := result.(*Sync); !ok {
+					t.Errorf("Unmarshal() expected *Sync, got %T", result)
+				}
+			case "Beacon":
+				if b, ok := result.(*Beacon); !ok || b.ID != "00ff" {
+					t.Errorf("Unmarshal() expected *Beacon from 00ff, got %#v", result)
+				}
+			case "Lookup":
+				if l, ok := result.(*Lookup); !ok || l.Key != "0011" {
+					t.Errorf("Unmarshal() expected *Lookup for 0011, got %#v", result)
+				}
+			case "Summary":
+				if s, ok := result.(*Summary); !ok || s.From != "00ff" || s.Filter != "7:AAAA" {
+					t.Errorf("Unmarshal() expected *Summary, got %#v", result)
+				}
+			case "Provide":
+				if p, ok := result.(*Provide); !ok || p.From != "00ff" || p.Key != "0011" {
+					t.Errorf("Unmarshal() expected *Provide, got %#v", result)
+				}
+			case "PingReq":
+				if _, ok := result.(*PingReq); !ok {
+					t.Errorf("Unmarshal() expected *PingReq, got %T", result)
 				}
 			case "Get":
 				if _, ok := result.(*Get); !ok {
 					t.Errorf("Unmarshal() expected *Get, got %T", result)
 				}
+			case "GetHash":
+				if _, ok := result.(*GetHash); !ok {
+					t.Errorf("Unmarshal() expected *GetHash, got %T", result)
+				}
+			case "Search":
+				if _, ok := result.(*Search); !ok {
+					t.Errorf("Unmarshal() expected *Search, got %T", result)
+				}
+			case "Result":
+				if _, ok := result.(*Result); !ok {
+					t.Errorf("Unmarshal() expected *Result, got %T", result)
+				}
+			case "List":
+				if _, ok := result.(*List); !ok {
+					t.Errorf("Unmarshal() expected *List, got %T", result)
+				}
+			case "Catalog":
+				if _, ok := result.(*Catalog); !ok {
+					t.Errorf("Unmarshal() expected *Catalog, got %T", result)
+				}
+			case "Ping":
+				if _, ok := result.(*Ping); !ok {
+					t.Errorf("Unmarshal() expected *Ping, got %T", result)
+				}
+			case "Pong":
+				if _, ok := result.(*Pong); !ok {
+					t.Errorf("Unmarshal() expected *Pong, got %T", result)
+				}
 			case "File":
 				if _, ok := result.(*File); !ok {
 					t.Errorf("Unmarshal() expected *File, got %T", result)
@@ -142,29 +604,28 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
-func TestUnmarshalDiscover(t *testing.T) {
-	input := "DISCOVER,127.0.0.1:1378,192.168.1.1:1379\n"
+func TestUnmarshalPingReq(t *testing.T) {
+	input := "PingReq,a1b2,1700000000000000000,10.0.0.1:1380,a42@127.0.0.1:1378\n"
 	result, err := Unmarshal(input)
 	if err != nil {
 		t.Fatalf("Unmarshal() error: %v", err)
 	}
 
-	discover, ok := result.(*Discover)
+	req, ok := result.(*PingReq)
 	if !ok {
-		t.Fatalf("Expected *Discover, got %T", result)
+		t.Fatalf("Expected *PingReq, got %T", result)
 	}
 
-	if len(discover.List) != 2 {
-		t.Errorf("Expected 2 addresses, got %d", len(discover.List))
+	if req.Target != "10.0.0.1:1380" {
+		t.Errorf("Target = %q, want %q", req.Target, "10.0.0.1:1380")
 	}
-
-	if discover.List[0] != "127.0.0.1:1378" {
-		t.Errorf("First address = %q, want %q", discover.List[0], "127.0.0.1:1378")
+	if len(req.Updates) != 1 || req.Updates[0].Addr != "127.0.0.1:1378" {
+		t.Errorf("Updates = %v, want the piggybacked update", req.Updates)
 	}
 }
 
 func TestUnmarshalGet(t *testing.T) {
-	input := "Get,resume.pdf"
+	input := "Get,a1b2,resume.pdf"
 	result, err := Unmarshal(input)
 	if err != nil {
 		t.Fatalf("Unmarshal() error: %v", err)
@@ -175,13 +636,16 @@ func TestUnmarshalGet(t *testing.T) {
 		t.Fatalf("Expected *Get, got %T", result)
 	}
 
+	if get.ID != "a1b2" {
+		t.Errorf("ID = %q, want %q", get.ID, "a1b2")
+	}
 	if get.Name != "resume.pdf" {
 		t.Errorf("Name = %q, want %q", get.Name, "resume.pdf")
 	}
 }
 
 func TestUnmarshalFile(t *testing.T) {
-	input := "File,1,33680"
+	input := "File,a1b2,1,33680,4096,9f86d0,e3b0c4"
 	result, err := Unmarshal(input)
 	if err != nil {
 		t.Fatalf("Unmarshal() error: %v", err)
@@ -192,35 +656,51 @@ func TestUnmarshalFile(t *testing.T) {
 		t.Fatalf("Expected *File, got %T", result)
 	}
 
+	if file.ID != "a1b2" {
+		t.Errorf("ID = %q, want %q", file.ID, "a1b2")
+	}
 	if file.Method != 1 {
 		t.Errorf("Method = %d, want %d", file.Method, 1)
 	}
 	if file.TCPPort != 33680 {
 		t.Errorf("TCPPort = %d, want %d", file.TCPPort, 33680)
 	}
+	if file.Size != 4096 {
+		t.Errorf("Size = %d, want %d", file.Size, 4096)
+	}
+	if file.Hash != "9f86d0" {
+		t.Errorf("Hash = %q, want %q", file.Hash, "9f86d0")
+	}
+	if file.Root != "e3b0c4" {
+		t.Errorf("Root = %q, want %q", file.Root, "e3b0c4")
+	}
 }
 
 func TestMarshalUnmarshalRoundTrip(t *testing.T) {
-	t.Run("Discover", func(t *testing.T) {
-		original := &Discover{List: []string{"127.0.0.1:1378", "192.168.1.1:1379"}}
-		marshaled := original.Marshal()
-		result, err := Unmarshal(marshaled)
+	t.Run("Ping", func(t *testing.T) {
+		original := &Ping{Nonce: NewID(), Timestamp: 1700000000000000000, Updates: []Update{
+			{State: Alive, Incarnation: 3, Addr: "192.168.1.1:1379", Meta: Meta{ID: NewID(), TCPPort: 40000, Version: 1, Capabilities: CapSearch}},
+		}}
+		result, err := Unmarshal(original.Marshal())
 		if err != nil {
 			t.Fatalf("Unmarshal() error: %v", err)
 		}
 
-		discover, ok := result.(*Discover)
+		ping, ok := result.(*Ping)
 		if !ok {
-			t.Fatalf("Expected *Discover, got %T", result)
+			t.Fatalf("Expected *Ping, got %T", result)
 		}
 
-		if len(discover.List) != len(original.List) {
-			t.Errorf("List length = %d, want %d", len(discover.List), len(original.List))
+		if ping.Nonce != original.Nonce || ping.Timestamp != original.Timestamp {
+			t.Errorf("Ping = %+v, want %+v", ping, original)
+		}
+		if len(ping.Updates) != 1 || ping.Updates[0] != original.Updates[0] {
+			t.Errorf("Updates = %v, want %v", ping.Updates, original.Updates)
 		}
 	})
 
 	t.Run("Get", func(t *testing.T) {
-		original := &Get{Name: "test.pdf"}
+		original := &Get{ID: NewID(), Name: "test.pdf"}
 		marshaled := original.Marshal()
 		result, err := Unmarshal(marshaled)
 		if err != nil {
@@ -232,13 +712,181 @@ func TestMarshalUnmarshalRoundTrip(t *testing.T) {
 			t.Fatalf("Expected *Get, got %T", result)
 		}
 
+		if get.ID != original.ID {
+			t.Errorf("ID = %q, want %q", get.ID, original.ID)
+		}
 		if get.Name != original.Name {
 			t.Errorf("Name = %q, want %q", get.Name, original.Name)
 		}
 	})
 
+	t.Run("Get with a comma in the name", func(t *testing.T) {
+		original := &Get{ID: NewID(), Name: "notes, part 1.txt", Offset: 1024}
+		marshaled := original.Marshal()
+		if strings.Contains(marshaled, original.Name) {
+			t.Errorf("Marshal() = %q, want the name escaped", marshaled)
+		}
+
+		result, err := Unmarshal(marshaled)
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+		if get, ok := result.(*Get); !ok || *get != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", result, original)
+		}
+	})
+
+	t.Run("Get with range", func(t *testing.T) {
+		original := &Get{ID: NewID(), Name: "test.pdf", Offset: 5 << 30, Length: 1 << 20}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		get, ok := result.(*Get)
+		if !ok {
+			t.Fatalf("Expected *Get, got %T", result)
+		}
+
+		if get.Offset != original.Offset || get.Length != original.Length {
+			t.Errorf("Range = %d+%d, want %d+%d", get.Offset, get.Length, original.Offset, original.Length)
+		}
+	})
+
+	t.Run("Forwarded Get", func(t *testing.T) {
+		original := &Get{ID: NewID(), Name: "test.pdf", TTL: 3, Origin: "127.0.0.1:1378"}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		if get, ok := result.(*Get); !ok || *get != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", result, original)
+		}
+	})
+
+	t.Run("Relayed File", func(t *testing.T) {
+		original := &File{ID: NewID(), Method: 1, TCPPort: 33680, Size: 4096, Hash: "9f86d0", Root: "e3b0c4", Addr: "10.0.0.7:33680"}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		if file, ok := result.(*File); !ok || *file != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", result, original)
+		}
+	})
+
+	t.Run("File with load", func(t *testing.T) {
+		original := &File{ID: NewID(), Method: 1, TCPPort: 33680, Size: 4096, Hash: "9f86d0", Root: "e3b0c4", Load: 3}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		if file, ok := result.(*File); !ok || *file != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", result, original)
+		}
+	})
+
+	t.Run("File with name", func(t *testing.T) {
+		original := &File{ID: NewID(), Method: 1, TCPPort: 33680, Size: 4096, Hash: "9f86d0", Root: "e3b0c4", Name: "résumé, final.pdf"}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		if file, ok := result.(*File); !ok || *file != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", result, original)
+		}
+	})
+
+	t.Run("GetHash", func(t *testing.T) {
+		original := &GetHash{ID: NewID(), Hash: "9f86d0", Offset: 1 << 20, Length: 1 << 20}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		getHash, ok := result.(*GetHash)
+		if !ok {
+			t.Fatalf("Expected *GetHash, got %T", result)
+		}
+
+		if *getHash != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", *getHash, *original)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		original := &Search{ID: NewID(), Query: "report, final"}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		search, ok := result.(*Search)
+		if !ok {
+			t.Fatalf("Expected *Search, got %T", result)
+		}
+
+		if *search != *original {
+			t.Errorf("Unmarshal() = %+v, want %+v", *search, *original)
+		}
+	})
+
+	t.Run("Result", func(t *testing.T) {
+		original := &Result{ID: NewID(), Matches: []Match{
+			{Name: "a,b:c d.txt", Size: 12, Hash: "9f86d0"},
+			{Name: "notes.txt", Size: 3, Hash: "e3b0c4"},
+		}}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		res, ok := result.(*Result)
+		if !ok {
+			t.Fatalf("Expected *Result, got %T", result)
+		}
+
+		if res.ID != original.ID {
+			t.Errorf("ID = %q, want %q", res.ID, original.ID)
+		}
+		if len(res.Matches) != len(original.Matches) {
+			t.Fatalf("Matches length = %d, want %d", len(res.Matches), len(original.Matches))
+		}
+		for i := range res.Matches {
+			if res.Matches[i] != original.Matches[i] {
+				t.Errorf("Matches[%d] = %+v, want %+v", i, res.Matches[i], original.Matches[i])
+			}
+		}
+	})
+
+	t.Run("Catalog", func(t *testing.T) {
+		original := &Catalog{ID: NewID(), Page: 1, Pages: 2, Matches: []Match{
+			{Name: "notes.txt", Size: 3, Hash: "e3b0c4"},
+		}}
+		result, err := Unmarshal(original.Marshal())
+		if err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+
+		catalog, ok := result.(*Catalog)
+		if !ok {
+			t.Fatalf("Expected *Catalog, got %T", result)
+		}
+
+		if catalog.Page != original.Page || catalog.Pages != original.Pages {
+			t.Errorf("Page = %d/%d, want %d/%d", catalog.Page, catalog.Pages, original.Page, original.Pages)
+		}
+		if len(catalog.Matches) != 1 || catalog.Matches[0] != original.Matches[0] {
+			t.Errorf("Matches = %+v, want %+v", catalog.Matches, original.Matches)
+		}
+	})
+
 	t.Run("File", func(t *testing.T) {
-		original := &File{Method: 1, TCPPort: 33680}
+		original := &File{ID: NewID(), Method: 1, TCPPort: 33680, Size: 5 << 30, Hash: "9f86d0", Root: "e3b0c4"}
 		marshaled := original.Marshal()
 		result, err := Unmarshal(marshaled)
 		if err != nil {
@@ -250,11 +898,37 @@ func TestMarshalUnmarshalRoundTrip(t *testing.T) {
 			t.Fatalf("Expected *File, got %T", result)
 		}
 
+		if file.ID != original.ID {
+			t.Errorf("ID = %q, want %q", file.ID, original.ID)
+		}
 		if file.Method != original.Method {
 			t.Errorf("Method = %d, want %d", file.Method, original.Method)
 		}
 		if file.TCPPort != original.TCPPort {
 			t.Errorf("TCPPort = %d, want %d", file.TCPPort, original.TCPPort)
 		}
+		if file.Size != original.Size {
+			t.Errorf("Size = %d, want %d", file.Size, original.Size)
+		}
+		if file.Hash != original.Hash {
+			t.Errorf("Hash = %q, want %q", file.Hash, original.Hash)
+		}
+		if file.Root != original.Root {
+			t.Errorf("Root = %q, want %q", file.Root, original.Root)
+		}
 	})
 }
+
+func TestNewID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := NewID()
+		if len(id) != 16 {
+			t.Errorf("NewID() length = %d, want %d", len(id), 16)
+		}
+		if seen[id] {
+			t.Errorf("NewID() returned duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,40 @@
+package reputation
+
+import (
+	"sort"
+	"time"
+)
+
+// unknownRTT stands in for the round trip time of a provider we never
+// pinged, somewhat worse than a peer on the same network
+const unknownRTT = 100 * time.Millisecond
+
+// Offer is a provider that answered a file request
+type Offer struct {
+	Addr string
+	// RTT is the last measured round trip time, zero when unknown
+	RTT time.Duration
+	// Load is the number of uploads the provider said it was serving
+	Load int
+	// Score is the provider's reputation
+	Score float64
+}
+
+// cost is the round trip time scaled up by the load the provider already
+// carries and down by its reputation, lower is better
+func (o Offer) cost() float64 {
+	rtt := o.RTT
+	if rtt <= 0 {
+		rtt = unknownRTT
+	}
+	return float64(rtt) * float64(1+max(o.Load, 0)) * (2 - o.Score)
+}
+
+// Rank orders offers from the best provider to the worst
+func Rank(offers []Offer) []Offer {
+	ranked := append([]Offer(nil), offers...)
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return ranked[a].cost() < ranked[b].cost()
+	})
+	return ranked
+}
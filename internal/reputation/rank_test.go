@@ -0,0 +1,27 @@
+package reputation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRank(t *testing.T) {
+	offers := []Offer{
+		{Addr: "unknown"},
+		{Addr: "busy", RTT: time.Millisecond, Load: 200},
+		{Addr: "near", RTT: time.Millisecond},
+		{Addr: "far", RTT: 50 * time.Millisecond},
+		{Addr: "trusted", RTT: 50 * time.Millisecond, Score: 0.9},
+	}
+
+	ranked := Rank(offers)
+	want := []string{"near", "trusted", "far", "unknown", "busy"}
+	for i, offer := range ranked {
+		if offer.Addr != want[i] {
+			t.Fatalf("Rank() = %v, want addresses %v", ranked, want)
+		}
+	}
+	if offers[0].Addr != "unknown" {
+		t.Errorf("Rank() reordered its argument")
+	}
+}
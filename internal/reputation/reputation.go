@@ -0,0 +1,165 @@
+package reputation
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReferenceThroughput is the throughput, in bytes per second, that counts
+// for half of the speed part of a score
+const ReferenceThroughput = 1 << 20
+
+// GoodScore is the score from which a peer's requests are answered without
+// any delay. Scores only approach 1, so a peer with a long record of fast
+// transfers would never be answered at once otherwise.
+const GoodScore = 0.5
+
+// forgotten is the weight below which a peer's record is dropped
+const forgotten = 0.01
+
+// Scores keeps the reputation of the peers we exchanged files with: the
+// ranges they served, how fast, and the ones that failed. Every count
+// decays by half every half-life, so old behavior matters less and less.
+type Scores struct {
+	peers    map[string]*record
+	halfLife time.Duration
+	mutex    sync.Mutex
+}
+
+// record holds a peer's decayed counts as of updated
+type record struct {
+	transfers float64
+	failures  float64
+	bytes     float64
+	seconds   float64
+	updated   time.Time
+}
+
+// Record is what the scores know about a peer
+type Record struct {
+	Peer      string
+	Transfers float64
+	Failures  float64
+	// Throughput is the average in bytes per second over the transfers
+	Throughput float64
+	Score      float64
+}
+
+func New(halfLife time.Duration) *Scores {
+	return &Scores{peers: make(map[string]*record), halfLife: halfLife}
+}
+
+// Transfer records that peer served bytes in elapsed
+func (s *Scores) Transfer(peer string, bytes int64, elapsed time.Duration, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r := s.get(peer, now)
+	r.transfers++
+	r.bytes += float64(bytes)
+	r.seconds += elapsed.Seconds()
+}
+
+// Failure records that a transfer from peer failed
+func (s *Scores) Failure(peer string, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.get(peer, now).failures++
+}
+
+// Score returns the score of peer at now, between 0 for a peer we know
+// nothing good about and 1 for one that served us often and fast
+func (s *Scores) Score(peer string, now time.Time) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, ok := s.peers[peer]
+	if !ok {
+		return 0
+	}
+	r.decay(now, s.halfLife)
+	return r.score()
+}
+
+// Records returns the records of every peer at now, the best first
+func (s *Scores) Records(now time.Time) []Record {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]Record, 0, len(s.peers))
+	for peer, r := range s.peers {
+		r.decay(now, s.halfLife)
+		if r.transfers+r.failures < forgotten {
+			delete(s.peers, peer)
+			continue
+		}
+		records = append(records, Record{
+			Peer:       peer,
+			Transfers:  r.transfers,
+			Failures:   r.failures,
+			Throughput: r.throughput(),
+			Score:      r.score(),
+		})
+	}
+
+	sort.Slice(records, func(a, b int) bool {
+		if records[a].Score != records[b].Score {
+			return records[a].Score > records[b].Score
+		}
+		return records[a].Peer < records[b].Peer
+	})
+	return records
+}
+
+// Delay maps a score to how long a request from the peer waits before we
+// answer it: max for a score of 0, shrinking linearly to nothing at
+// GoodScore and above
+func Delay(score float64, max time.Duration) time.Duration {
+	score = math.Min(math.Max(score, 0), GoodScore)
+	return time.Duration((1 - score/GoodScore) * float64(max))
+}
+
+// get returns the record of peer decayed to now, creating it if needed.
+// The caller must hold the mutex.
+func (s *Scores) get(peer string, now time.Time) *record {
+	r, ok := s.peers[peer]
+	if !ok {
+		r = &record{updated: now}
+		s.peers[peer] = r
+	}
+	r.decay(now, s.halfLife)
+	return r
+}
+
+// decay brings the counts from updated forward to now
+func (r *record) decay(now time.Time, halfLife time.Duration) {
+	elapsed := now.Sub(r.updated)
+	if elapsed <= 0 || halfLife <= 0 {
+		return
+	}
+
+	factor := math.Exp2(-elapsed.Seconds() / halfLife.Seconds())
+	r.transfers *= factor
+	r.failures *= factor
+	r.bytes *= factor
+	r.seconds *= factor
+	r.updated = now
+}
+
+func (r *record) throughput() float64 {
+	if r.seconds <= 0 {
+		return 0
+	}
+	return r.bytes / r.seconds
+}
+
+// score weighs how reliable the peer is, the share of transfers that
+// succeeded with one failure assumed up front, by how fast it is
+func (r *record) score() float64 {
+	reliability := r.transfers / (r.transfers + r.failures + 1)
+	speed := r.throughput() / (r.throughput() + ReferenceThroughput)
+	return reliability * (1 + speed) / 2
+}
@@ -0,0 +1,101 @@
+package reputation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnknownPeer(t *testing.T) {
+	s := New(time.Hour)
+	if score := s.Score("aa", time.Now()); score != 0 {
+		t.Errorf("Score() = %v for an unknown peer, want 0", score)
+	}
+}
+
+func TestTransfersRaiseScore(t *testing.T) {
+	s := New(time.Hour)
+	now := time.Now()
+
+	previous := 0.0
+	for range 10 {
+		s.Transfer("aa", 4<<20, time.Second, now)
+		score := s.Score("aa", now)
+		if score <= previous || score >= 1 {
+			t.Fatalf("Score() = %v after a transfer, want above %v and below 1", score, previous)
+		}
+		previous = score
+	}
+
+	// A slow peer scores lower than a fast one
+	for range 10 {
+		s.Transfer("bb", 64<<10, time.Second, now)
+	}
+	if slow := s.Score("bb", now); slow >= previous {
+		t.Errorf("slow peer scores %v, fast peer %v", slow, previous)
+	}
+}
+
+func TestFailuresLowerScore(t *testing.T) {
+	s := New(time.Hour)
+	now := time.Now()
+
+	s.Transfer("aa", 1<<20, time.Second, now)
+	s.Transfer("aa", 1<<20, time.Second, now)
+	before := s.Score("aa", now)
+
+	s.Failure("aa", now)
+	if after := s.Score("aa", now); after >= before {
+		t.Errorf("Score() = %v after a failure, want below %v", after, before)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	s := New(time.Hour)
+	now := time.Now()
+
+	s.Transfer("aa", 1<<20, time.Second, now)
+	s.Transfer("aa", 1<<20, time.Second, now)
+
+	records := s.Records(now.Add(time.Hour))
+	if len(records) != 1 || records[0].Transfers != 1 {
+		t.Errorf("Records() an hour later = %+v, want half the transfers", records)
+	}
+	if records[0].Throughput != 1<<20 {
+		t.Errorf("Throughput = %v, want it unchanged by decay", records[0].Throughput)
+	}
+
+	if records := s.Records(now.Add(10 * time.Hour)); len(records) != 0 {
+		t.Errorf("Records() after ten half-lives = %+v, want the peer forgotten", records)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  time.Duration
+	}{
+		{score: 0, want: 10 * time.Second},
+		{score: 0.125, want: 7500 * time.Millisecond},
+		{score: GoodScore, want: 0},
+		{score: 0.75, want: 0},
+		{score: 1, want: 0},
+		{score: 2, want: 0},
+		{score: -1, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := Delay(tt.score, 10*time.Second); got != tt.want {
+			t.Errorf("Delay(%v) = %v, want %v", tt.score, got, tt.want)
+		}
+	}
+
+	// A peer that served a few fast, reliable transfers isn't kept waiting
+	s := New(time.Hour)
+	now := time.Now()
+	for range 5 {
+		s.Transfer("aa", 4<<20, time.Second, now)
+	}
+	if delay := Delay(s.Score("aa", now), 10*time.Second); delay != 0 {
+		t.Errorf("Delay() = %v for a good peer, want 0", delay)
+	}
+}
@@ -2,166 +2,713 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/pterm/pterm"
-
 	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/merkle"
 	"github.com/1995parham-teaching/P2P/internal/message"
 )
 
 const (
 	// dialTimeout is the timeout for TCP connection attempts
 	dialTimeout = 10 * time.Second
+
+	// maxProviderFailures is the number of failed chunks after which a
+	// provider is dropped from a swarming download
+	maxProviderFailures = 3
+
+	// swarmSize is how many providers serve a download at once, the others
+	// stand by to replace the ones that are dropped
+	swarmSize = 4
+
+	// stallTimeout is how long a transfer may go without receiving any data
+	// before the range is given up on
+	stallTimeout = 15 * time.Second
+
+	// maxAttempts is how many times a download is tried before it is given
+	// up on, each attempt resuming the previous one
+	maxAttempts = 3
+
+	// retryBackoff is the wait before the second attempt, it doubles with
+	// every attempt after that
+	retryBackoff = 2 * time.Second
+
+	// leavesSuffix names the file next to a partial download that records
+	// the leaf hash of every chunk verified so far
+	leavesSuffix = ".leaves"
 )
 
+var (
+	// ErrIntegrity is returned when downloaded content doesn't match its hash
+	ErrIntegrity = errors.New("content integrity check failed")
+
+	// ErrInProgress is returned when the same content is already being
+	// downloaded into the folder
+	ErrInProgress = errors.New("download already in progress")
+)
+
+// Request describes a file to download and the peers that offered it,
+// the best ones first. Hash is the hex encoded SHA-256 the downloaded
+// content must match and Root the Merkle root every chunk is verified
+// against. ByHash requests the content by Hash, Name is then the name the
+// provider gave in its File reply, if any. Without one the name in the
+// transfer header is used, and the hash when that is missing too.
+type Request struct {
+	Name      string
+	Size      int64
+	Hash      string
+	Root      string
+	ByHash    bool
+	Providers []string
+	// Progress, if set, is called every time a chunk is verified. Calls are
+	// never concurrent.
+	Progress func(Progress)
+}
+
+// Progress is how far a download got
+type Progress struct {
+	Label string
+	// Received is the number of bytes verified, counting the resumed ones
+	Received int64
+	Size     int64
+	// Attempt is the number of the attempt in progress, starting at 1
+	Attempt int
+}
+
+// Result describes a downloaded file
+type Result struct {
+	// Name is the file name it was saved under and Path where
+	Name string
+	Path string
+	Size int64
+	Hash string
+	// Attempts is how many times the download was tried
+	Attempts int
+}
+
+// AttemptsError is returned when every attempt to download a file failed
+type AttemptsError struct {
+	Label    string
+	Attempts int
+	// Err is the error of the last attempt
+	Err error
+}
+
+func (e *AttemptsError) Error() string {
+	return fmt.Sprintf("downloading %s failed after %d attempt(s): %v", e.Label, e.Attempts, e.Err)
+}
+
+func (e *AttemptsError) Unwrap() error {
+	return e.Err
+}
+
+// Label returns a human readable name for the requested file
+func (r Request) Label() string {
+	if r.ByHash {
+		return index.Link(r.Hash)
+	}
+	return r.Name
+}
+
+// Reporter is told how every range fetched from a provider went, so
+// providers can be scored
+type Reporter interface {
+	Transferred(provider string, bytes int64, elapsed time.Duration)
+	Failed(provider string)
+}
+
 type Client struct {
 	folder string
+	log    logger.Logger
+	// Reporter, if set, hears about every range fetched
+	Reporter Reporter
+
+	mutex  sync.Mutex
+	active map[string]bool // hashes being downloaded
 }
 
-func New(folder string) *Client {
-	return &Client{folder: folder}
+func New(folder string, log logger.Logger) *Client {
+	return &Client{folder: folder, log: log, active: make(map[string]bool)}
 }
 
-// Connect listens for file download requests and processes them
-func (c *Client) Connect(ctx context.Context, addr <-chan string, fileName <-chan string) error {
-	pterm.Debug.Println("TCP client ready and waiting for download requests")
-	for {
+// Download fetches req from its providers and saves it in the shared
+// folder. It tries up to maxAttempts times, backing off between attempts,
+// and every attempt resumes what the previous ones verified and leads with
+// another provider. When they all fail it returns an *AttemptsError, and
+// the context's error when ctx is done first. A download of content that
+// is already being downloaded fails with ErrInProgress.
+func (c *Client) Download(ctx context.Context, req Request) (*Result, error) {
+	if !c.claim(req.Hash) {
+		return nil, fmt.Errorf("%w: %s", ErrInProgress, req.Label())
+	}
+	defer c.release(req.Hash)
+
+	c.log.Debugf("Starting download: %s from %d peer(s)", req.Label(), len(req.Providers))
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := c.downloadFile(ctx, req, attempt)
+		if err == nil {
+			result.Attempts = attempt
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt == maxAttempts {
+			return nil, &AttemptsError{Label: req.Label(), Attempts: attempt, Err: err}
+		}
+
+		c.log.Warnf("Attempt %d of %d to download %s failed, retrying in %v: %v",
+			attempt, maxAttempts, req.Label(), backoff, err)
+
 		select {
 		case <-ctx.Done():
-			pterm.Debug.Println("TCP client shutting down")
-			return nil
-		case serverAddr := <-addr:
-			pterm.Debug.Printf("Received server address: %s, waiting for filename...\n", serverAddr)
-			// Use select to avoid blocking forever if fileName never arrives
-			select {
-			case <-ctx.Done():
-				pterm.Debug.Println("TCP client shutting down while waiting for filename")
-				return nil
-			case fName := <-fileName:
-				pterm.Info.Printf("Starting download: %s from %s\n", fName, serverAddr)
-				if err := c.downloadFile(serverAddr, fName); err != nil {
-					pterm.Error.Printf("Failed to download file: %v\n", err)
-				}
-			}
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		if len(req.Providers) > 1 {
+			req.Providers = append(slices.Clone(req.Providers[1:]), req.Providers[0])
 		}
 	}
 }
 
-func (c *Client) downloadFile(serverAddr, fileName string) error {
-	pterm.Info.Printf("Connecting to %s...\n", serverAddr)
+// claim marks hash as being downloaded, it reports false when it already is
+func (c *Client) claim(hash string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	conn, err := net.DialTimeout("tcp", serverAddr, dialTimeout)
-	if err != nil {
-		return fmt.Errorf("failed to connect to %s (timeout: %v): %w", serverAddr, dialTimeout, err)
+	if c.active[hash] {
+		return false
 	}
-	defer func() { _ = conn.Close() }()
+	c.active[hash] = true
+	return true
+}
 
-	pterm.Success.Printf("Connected to %s\n", serverAddr)
+// release ends the claim on hash
+func (c *Client) release(hash string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	// Send the file request
-	if err := c.sendRequest(conn, fileName); err != nil {
-		return err
+	delete(c.active, hash)
+}
+
+// chunk is a byte range of a download, it starts on a ChunkSize boundary
+type chunk struct {
+	offset int64
+	length int64
+}
+
+// downloadFile fetches req into a "downloading_<hash>" file, resuming the
+// prefix an earlier attempt verified, and renames it into place once its
+// hash checks out
+func (c *Client) downloadFile(ctx context.Context, req Request, attempt int) (*Result, error) {
+	if len(req.Providers) == 0 {
+		return nil, fmt.Errorf("no providers for %s", req.Label())
+	}
+
+	root, err := merkle.ParseHash(req.Root)
+	if err != nil {
+		return nil, err
 	}
+	if _, ok := index.ParseLink(index.Link(req.Hash)); !ok {
+		return nil, fmt.Errorf("invalid hash %q for %s", req.Hash, req.Label())
+	}
+
+	// The partial file is keyed by content, so downloads of different
+	// files with the same name don't share it
+	outputPath := filepath.Join(c.folder, index.DownloadingPrefix+req.Hash)
+	leavesPath := outputPath + leavesSuffix
 
-	// Read file size
-	bufferFileSize := make([]byte, config.FileSizeLength)
-	if _, err := io.ReadFull(conn, bufferFileSize); err != nil {
-		return err
+	name := req.Name
+	if req.ByHash && !validName(req.Name) {
+		name = req.Hash
 	}
 
-	fileSize, err := strconv.ParseInt(strings.TrimRight(string(bufferFileSize), ":"), 10, 64)
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Read file name
-	bufferFileName := make([]byte, config.FileNameLength)
-	if _, err := io.ReadFull(conn, bufferFileName); err != nil {
-		return err
+	leavesFile, err := os.OpenFile(leavesPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
 	}
 
-	receivedFileName := strings.TrimRight(string(bufferFileName), ":")
+	// Only the chunks recorded as verified, and still matching what was
+	// recorded, are kept
+	offset := resumable(file, leavesFile, req.Size)
+	if err := file.Truncate(offset); err != nil {
+		_ = file.Close()
+		_ = leavesFile.Close()
+		return nil, err
+	}
+	if offset > 0 {
+		c.log.Infof("Resuming %s from byte %d", req.Label(), offset)
+	}
 
-	// Create output file with "downloading_" prefix to indicate in-progress download
-	outputPath := filepath.Join(c.folder, "downloading_"+filepath.Base(receivedFileName))
-	finalPath := filepath.Join(c.folder, filepath.Base(receivedFileName))
+	chunks := planChunks(offset, req.Size, len(req.Providers))
 
-	newFile, err := os.Create(outputPath)
-	if err != nil {
-		return err
+	t := &transfer{
+		req:       req,
+		root:      root,
+		name:      name,
+		leaves:    int((req.Size + config.ChunkSize - 1) / config.ChunkSize),
+		file:      file,
+		record:    leavesFile,
+		completed: make(map[int64]bool),
+		attempt:   attempt,
+		received:  offset,
 	}
+	t.report()
 
-	// Create progress bar
-	progressBar, _ := pterm.DefaultProgressbar.
-		WithTotal(int(fileSize)).
-		WithTitle("Downloading " + receivedFileName).
-		WithShowPercentage(true).
-		WithShowElapsedTime(true).
-		Start()
+	err = c.swarm(ctx, t, chunks)
+	if err == nil && ctx.Err() != nil {
+		// Canceled as the last range came in, the caller gave up on it
+		err = ctx.Err()
+	}
 
-	// Read file content with progress
-	if err := c.readFileContentWithProgress(conn, fileSize, newFile, progressBar); err != nil {
-		_ = newFile.Close()
-		_ = os.Remove(outputPath) // Clean up partial file
-		return err
+	if err != nil {
+		// Keep only the contiguous prefix so the next attempt can resume it
+		prefix := t.contiguous(offset)
+		_ = file.Truncate(prefix)
+		_ = file.Close()
+		_ = leavesFile.Close()
+		return nil, fmt.Errorf("download interrupted at byte %d, partial file kept for resume: %w", prefix, err)
 	}
 
-	_, _ = progressBar.Stop()
+	_ = leavesFile.Close()
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
 
-	if err := newFile.Close(); err != nil {
-		return err
+	// The partial file can't be trusted for a resume either, so a mismatch
+	// discards it completely
+	hash, err := index.HashFile(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(leavesPath)
+	if hash != req.Hash {
+		_ = os.Remove(outputPath)
+		return nil, fmt.Errorf("%w: %s has hash %s, want %s", ErrIntegrity, req.Label(), hash, req.Hash)
 	}
 
 	// Rename to final path after successful download
+	name = filepath.Base(t.fileName())
+	finalPath := filepath.Join(c.folder, name)
 	if err := os.Rename(outputPath, finalPath); err != nil {
-		return err
+		return nil, err
 	}
 
-	pterm.Success.Printf("File saved: %s\n", finalPath)
-	return nil
+	return &Result{Name: name, Path: finalPath, Size: req.Size, Hash: hash}, nil
 }
 
-func (c *Client) sendRequest(conn io.Writer, fileName string) error {
-	msg := (&message.Get{Name: fileName}).Marshal()
-	_, err := conn.Write([]byte(msg))
-	return err
-}
+// resumable returns the length of the prefix of a partial file of the
+// given size whose chunks match the leaf hashes recorded for them. A chunk
+// that was never recorded, like a hole an out of order range left, ends it.
+func resumable(file, record *os.File, size int64) int64 {
+	buffer := make([]byte, config.ChunkSize)
 
-func (c *Client) readFileContentWithProgress(conn io.Reader, fileSize int64, dest io.Writer, progressBar *pterm.ProgressbarPrinter) error {
-	buffer := make([]byte, config.BufferSize)
-	var totalWritten int64
+	var offset int64
+	for offset < size {
+		data := buffer[:min(config.ChunkSize, size-offset)]
+		if _, err := file.ReadAt(data, offset); err != nil {
+			break
+		}
 
-	for totalWritten < fileSize {
-		n, err := conn.Read(buffer)
-		if err != nil && err != io.EOF {
-			return err
+		var recorded merkle.Hash
+		leaf := offset / config.ChunkSize
+		if _, err := record.ReadAt(recorded[:], leaf*int64(len(recorded))); err != nil {
+			break
+		}
+		if merkle.LeafHash(data) != recorded {
+			break
 		}
 
-		if n > 0 {
-			written, err := dest.Write(buffer[:n])
+		offset += int64(len(data))
+	}
+	return offset
+}
+
+// validName reports whether a file name from a provider can be saved in the
+// shared folder as is
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// planChunks splits [offset, size) into ranges. A single provider gets the
+// whole range in one transfer, several providers get ChunkSize pieces.
+func planChunks(offset, size int64, providers int) []chunk {
+	chunkSize := int64(config.ChunkSize)
+	if providers <= 1 {
+		chunkSize = size - offset
+	}
+
+	chunks := make([]chunk, 0)
+	for start := offset; start < size; start += chunkSize {
+		chunks = append(chunks, chunk{offset: start, length: min(chunkSize, size-start)})
+	}
+	return chunks
+}
+
+// transfer is the shared state of the workers of one download
+type transfer struct {
+	req    Request
+	root   merkle.Hash
+	leaves int
+	file   *os.File
+	record *os.File // leaf hashes of the verified chunks
+
+	mutex     sync.Mutex
+	name      string         // file name to save as, the provider's for ByHash requests
+	completed map[int64]bool // offsets of verified ChunkSize chunks
+	attempt   int
+	received  int64 // bytes verified, counting the resumed ones
+}
+
+// named records the file name a provider reported in the transfer header
+// for a ByHash request that came without one
+func (t *transfer) named(name string) {
+	if !t.req.ByHash || t.req.Name != "" || !validName(name) {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.name = name
+}
+
+// fileName returns the name the download is saved as
+func (t *transfer) fileName() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.name
+}
+
+// verified records a chunk that passed its Merkle proof and was written
+func (t *transfer) verified(offset int64, length int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.completed[offset] = true
+	t.received += int64(length)
+	t.report()
+}
+
+// report passes the progress of the transfer to the request's callback.
+// The caller must hold the mutex, unless no worker runs yet.
+func (t *transfer) report() {
+	if t.req.Progress == nil {
+		return
+	}
+	t.req.Progress(Progress{Label: t.req.Label(), Received: t.received, Size: t.req.Size, Attempt: t.attempt})
+}
+
+// contiguous returns the end of the verified prefix starting at offset
+func (t *transfer) contiguous(offset int64) int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for offset < t.req.Size && t.completed[offset] {
+		offset = min(offset+config.ChunkSize, t.req.Size)
+	}
+	return offset
+}
+
+// swarm runs a worker for each of the best swarmSize providers, pulling
+// ranges from a shared queue. When a range fails or stalls, whatever wasn't
+// verified goes back to the queue for another provider, so a single bad
+// chunk is fetched again instead of the whole range. A provider that keeps
+// failing is dropped and the best one standing by takes over its worker.
+func (c *Client) swarm(ctx context.Context, t *transfer, chunks []chunk) error {
+	queue := make(chan chunk, len(chunks))
+	for _, ch := range chunks {
+		queue <- ch
+	}
+
+	providers := make(chan string, len(t.req.Providers))
+	for _, provider := range t.req.Providers {
+		providers <- provider
+	}
+	close(providers)
+
+	var (
+		mutex     sync.Mutex
+		remaining = len(chunks)
+		done      = make(chan struct{})
+		lastErr   error
+		wg        sync.WaitGroup
+	)
+
+	if remaining == 0 {
+		return nil
+	}
+
+	// work fetches ranges from provider until the download is over, which it
+	// reports, or the provider is dropped
+	work := func(provider string) bool {
+		failures := 0
+		for {
+			var ch chunk
+			select {
+			case <-ctx.Done():
+				return true
+			case <-done:
+				return true
+			case ch = <-queue:
+			}
+
+			start := time.Now()
+			next, err := c.fetchRange(ctx, provider, t, ch)
+			if err != nil && ctx.Err() != nil {
+				// Canceled, which is no fault of the provider
+				return true
+			}
+			c.report(provider, next-ch.offset, time.Since(start), err)
 			if err != nil {
-				return err
+				c.log.Warnf("Range at %d from %s failed at byte %d: %v", ch.offset, provider, next, err)
+				queue <- chunk{offset: next, length: ch.offset + ch.length - next}
+
+				mutex.Lock()
+				lastErr = err
+				mutex.Unlock()
+
+				failures++
+				if failures >= maxProviderFailures {
+					c.log.Warnf("Dropping provider %s after %d failures", provider, failures)
+					return false
+				}
+				continue
 			}
-			totalWritten += int64(written)
-			progressBar.Add(written)
-		}
 
-		if err == io.EOF {
-			break
+			mutex.Lock()
+			remaining--
+			if remaining == 0 {
+				close(done)
+			}
+			mutex.Unlock()
 		}
 	}
 
-	if totalWritten != fileSize {
-		pterm.Warning.Printf("Expected %d bytes, got %d\n", fileSize, totalWritten)
+	for range min(len(t.req.Providers), swarmSize) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for provider := range providers {
+				if work(provider) {
+					return
+				}
+			}
+		}()
 	}
 
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if remaining > 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("all providers failed: %w", lastErr)
+	}
 	return nil
 }
+
+// report tells the reporter how fetching a range from provider went
+func (c *Client) report(provider string, bytes int64, elapsed time.Duration, err error) {
+	if c.Reporter == nil {
+		return
+	}
+	if err != nil {
+		c.Reporter.Failed(provider)
+		return
+	}
+	c.Reporter.Transferred(provider, bytes, elapsed)
+}
+
+// fetchRange downloads a range from serverAddr, verifying every chunk
+// against the Merkle root before writing it at its offset. It returns the
+// offset up to which the range has been verified. The connection is closed
+// when ctx is done, which aborts the transfer.
+func (c *Client) fetchRange(ctx context.Context, serverAddr string, t *transfer, ch chunk) (int64, error) {
+	c.log.Debugf("Connecting to %s for bytes %d-%d...", serverAddr, ch.offset, ch.offset+ch.length)
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	dialed, err := dialer.DialContext(ctx, "tcp", serverAddr)
+	if err != nil {
+		return ch.offset, fmt.Errorf("failed to connect to %s (timeout: %v): %w", serverAddr, dialTimeout, err)
+	}
+	defer func() { _ = dialed.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = dialed.Close() })
+	defer stop()
+	conn := idleConn{Conn: dialed, timeout: stallTimeout}
+
+	// Send the file request
+	if err := c.sendRequest(conn, t.req, ch.offset, ch.length); err != nil {
+		return ch.offset, err
+	}
+
+	h, err := readHeader(conn)
+	if err != nil {
+		return ch.offset, err
+	}
+
+	if h.hash != t.req.Hash {
+		return ch.offset, fmt.Errorf("%w: %s serves a different version (hash %s)", ErrIntegrity, serverAddr, h.hash)
+	}
+
+	if h.offset != ch.offset || h.length != ch.length {
+		return ch.offset, fmt.Errorf("%w: asked for %d+%d, got %d+%d", message.ErrInvalidRange,
+			ch.offset, ch.length, h.offset, h.length)
+	}
+
+	t.named(h.name)
+
+	return c.readChunks(conn, t, ch)
+}
+
+// idleConn fails a read that waits longer than timeout for data, so a
+// provider that stalls doesn't hold on to its range forever
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	if err := c.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// readChunks reads the proof and data of every chunk in a range and writes
+// the chunks that verify. It stops at the first chunk that doesn't.
+func (c *Client) readChunks(conn io.Reader, t *transfer, ch chunk) (int64, error) {
+	buffer := make([]byte, config.ChunkSize)
+	end := ch.offset + ch.length
+
+	for pos := ch.offset; pos < end; pos += config.ChunkSize {
+		proof, err := readProof(conn)
+		if err != nil {
+			return pos, err
+		}
+
+		data := buffer[:min(config.ChunkSize, end-pos)]
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return pos, err
+		}
+
+		leaf := int(pos / config.ChunkSize)
+		hash := merkle.LeafHash(data)
+		if !merkle.Verify(t.root, hash, leaf, t.leaves, proof) {
+			return pos, fmt.Errorf("%w: chunk %d does not match the merkle root", ErrIntegrity, leaf)
+		}
+
+		if _, err := t.file.WriteAt(data, pos); err != nil {
+			return pos, err
+		}
+		if _, err := t.record.WriteAt(hash[:], int64(leaf)*int64(len(hash))); err != nil {
+			return pos, err
+		}
+
+		t.verified(pos, len(data))
+	}
+
+	return end, nil
+}
+
+// readProof reads a one byte hash count followed by the proof hashes
+func readProof(conn io.Reader) ([]merkle.Hash, error) {
+	var count [1]byte
+	if _, err := io.ReadFull(conn, count[:]); err != nil {
+		return nil, err
+	}
+
+	proof := make([]merkle.Hash, count[0])
+	for i := range proof {
+		if _, err := io.ReadFull(conn, proof[i][:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return proof, nil
+}
+
+// header is the fixed-size preamble the TCP server sends before file data
+type header struct {
+	size   int64
+	name   string
+	offset int64
+	length int64
+	hash   string
+}
+
+func readHeader(conn io.Reader) (*header, error) {
+	buffer := make([]byte, 3*config.FileSizeLength+config.FileNameLength+config.FileHashLength)
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return nil, err
+	}
+
+	field := func(from, length int) string {
+		return strings.TrimRight(string(buffer[from:from+length]), ":")
+	}
+
+	var h header
+	var err error
+
+	pos := 0
+	if h.size, err = strconv.ParseInt(field(pos, config.FileSizeLength), 10, 64); err != nil {
+		return nil, err
+	}
+	pos += config.FileSizeLength
+
+	h.name = field(pos, config.FileNameLength)
+	pos += config.FileNameLength
+
+	if h.offset, err = strconv.ParseInt(field(pos, config.FileSizeLength), 10, 64); err != nil {
+		return nil, err
+	}
+	pos += config.FileSizeLength
+
+	if h.length, err = strconv.ParseInt(field(pos, config.FileSizeLength), 10, 64); err != nil {
+		return nil, err
+	}
+	pos += config.FileSizeLength
+
+	h.hash = field(pos, config.FileHashLength)
+
+	return &h, nil
+}
+
+func (c *Client) sendRequest(conn io.Writer, req Request, offset, length int64) error {
+	var msg string
+	if req.ByHash {
+		msg = (&message.GetHash{ID: message.NewID(), Hash: req.Hash, Offset: offset, Length: length}).Marshal()
+	} else {
+		msg = (&message.Get{ID: message.NewID(), Name: req.Name, Offset: offset, Length: length}).Marshal()
+	}
+
+	_, err := conn.Write([]byte(msg))
+	return err
+}
@@ -0,0 +1,606 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/merkle"
+	tcp "github.com/1995parham-teaching/P2P/internal/tcp/server"
+)
+
+func TestPlanChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		offset    int64
+		size      int64
+		providers int
+		expected  int
+	}{
+		{
+			name:      "single provider gets one transfer",
+			offset:    0,
+			size:      5 * config.ChunkSize,
+			providers: 1,
+			expected:  1,
+		},
+		{
+			name:      "several providers split into chunks",
+			offset:    0,
+			size:      5*config.ChunkSize + 1,
+			providers: 3,
+			expected:  6,
+		},
+		{
+			name:      "resume skips the downloaded prefix",
+			offset:    2 * config.ChunkSize,
+			size:      5 * config.ChunkSize,
+			providers: 2,
+			expected:  3,
+		},
+		{
+			name:      "nothing left to download",
+			offset:    config.ChunkSize,
+			size:      config.ChunkSize,
+			providers: 2,
+			expected:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := planChunks(tt.offset, tt.size, tt.providers)
+			if len(chunks) != tt.expected {
+				t.Fatalf("planChunks() returned %d chunks, want %d", len(chunks), tt.expected)
+			}
+
+			// Chunks must cover [offset, size) without gaps
+			next := tt.offset
+			for _, ch := range chunks {
+				if ch.offset != next {
+					t.Errorf("chunk offset = %d, want %d", ch.offset, next)
+				}
+				next = ch.offset + ch.length
+			}
+			if next != tt.size {
+				t.Errorf("chunks end at %d, want %d", next, tt.size)
+			}
+		})
+	}
+}
+
+func TestContiguous(t *testing.T) {
+	tr := &transfer{
+		req: Request{Size: 4*config.ChunkSize - 10},
+		completed: map[int64]bool{
+			0:                    true,
+			config.ChunkSize:     true,
+			3 * config.ChunkSize: true,
+		},
+	}
+
+	if got := tr.contiguous(0); got != 2*config.ChunkSize {
+		t.Errorf("contiguous() = %d, want %d", got, 2*config.ChunkSize)
+	}
+
+	tr.completed[2*config.ChunkSize] = true
+	if got := tr.contiguous(0); got != tr.req.Size {
+		t.Errorf("contiguous() with every chunk = %d, want %d", got, tr.req.Size)
+	}
+
+	if got := tr.contiguous(config.ChunkSize); got != tr.req.Size {
+		t.Errorf("contiguous() from a resume offset = %d, want %d", got, tr.req.Size)
+	}
+}
+
+func TestIdleConnStalls(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = local.Close() }()
+	defer func() { _ = remote.Close() }()
+
+	conn := idleConn{Conn: local, timeout: 50 * time.Millisecond}
+
+	go func() { _, _ = remote.Write([]byte("data")) }()
+	buffer := make([]byte, 4)
+	if _, err := conn.Read(buffer); err != nil {
+		t.Fatalf("Read() error = %v while data arrives", err)
+	}
+
+	// The provider goes quiet
+	if _, err := conn.Read(buffer); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want a deadline error", err)
+	}
+}
+
+func TestDownloadCanceledWhileBackingOff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Without providers every attempt fails at once
+	result, err := New(t.TempDir(), logger.Discard).Download(ctx, Request{Name: "a.txt"})
+	if result != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Download() = %+v, %v, want the context's error", result, err)
+	}
+}
+
+func TestAttemptsError(t *testing.T) {
+	var err error = &AttemptsError{Label: "a.txt", Attempts: 3, Err: ErrIntegrity}
+
+	if !errors.Is(err, ErrIntegrity) {
+		t.Errorf("errors.Is(%v, ErrIntegrity) = false, want the last error unwrapped", err)
+	}
+
+	var attempts *AttemptsError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &attempts) || attempts.Attempts != 3 {
+		t.Errorf("errors.As() = %+v, want the attempts", attempts)
+	}
+}
+
+// recorder is a Reporter that counts the ranges every provider served and
+// failed, and calls onFailure with the failures so far after every one
+type recorder struct {
+	mutex       sync.Mutex
+	transferred map[string]int
+	failed      map[string]int
+	onFailure   func(provider string, failures int)
+}
+
+func newRecorder() *recorder {
+	return &recorder{transferred: make(map[string]int), failed: make(map[string]int)}
+}
+
+func (r *recorder) Transferred(provider string, _ int64, _ time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.transferred[provider]++
+}
+
+func (r *recorder) Failed(provider string) {
+	r.mutex.Lock()
+	r.failed[provider]++
+	failures := r.failed[provider]
+	r.mutex.Unlock()
+
+	if r.onFailure != nil {
+		r.onFailure(provider, failures)
+	}
+}
+
+func (r *recorder) counts(provider string) (int, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.transferred[provider], r.failed[provider]
+}
+
+// share writes content to name in a new folder and serves it over TCP. With
+// corrupt set every chunk is changed on disk after it was indexed, so the
+// provider announces the right hash and proofs but sends bad data.
+func share(t *testing.T, name string, content []byte, corrupt bool) (string, index.Entry) {
+	t.Helper()
+
+	folder := t.TempDir()
+	path := filepath.Join(folder, name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := index.New(folder, logger.Discard)
+	entry, ok := idx.Get(name)
+	if !ok {
+		t.Fatalf("%s is not indexed", name)
+	}
+
+	if corrupt {
+		bad := bytes.Clone(content)
+		for pos := 0; pos < len(bad); pos += config.ChunkSize {
+			bad[pos] ^= 0xff
+		}
+		if err := os.WriteFile(path, bad, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the entry fresh so the stale tree is served
+		if err := os.Chtimes(path, entry.ModTime, entry.ModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := tcp.New(idx, "127.0.0.1", logger.Discard)
+	port, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := server.Up(ctx); err != nil {
+			t.Errorf("Up() error = %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return fmt.Sprintf("127.0.0.1:%d", port), entry
+}
+
+// relay passes every connection it accepts on to target once gate is
+// closed. With a limit above zero it plays a provider that dies mid-transfer:
+// the first connection is cut after limit bytes from target, died is closed
+// and no connection is accepted afterwards.
+func relay(t *testing.T, target string, gate <-chan struct{}, limit int64) (string, <-chan struct{}) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	died := make(chan struct{})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+				<-gate
+
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer func() { _ = upstream.Close() }()
+
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				if limit <= 0 {
+					_, _ = io.Copy(conn, upstream)
+					return
+				}
+
+				_, _ = io.CopyN(conn, upstream, limit)
+				_ = listener.Close()
+				close(died)
+			}()
+
+			if limit > 0 {
+				return
+			}
+		}
+	}()
+
+	return listener.Addr().String(), died
+}
+
+// content returns size bytes that differ from chunk to chunk
+func content(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/config.ChunkSize)
+	}
+	return data
+}
+
+// checkDownload fails the test unless result holds exactly data
+func checkDownload(t *testing.T, result *Result, data []byte) {
+	t.Helper()
+
+	saved, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(saved)
+	want := sha256.Sum256(data)
+	if sum != want || result.Hash != hex.EncodeToString(want[:]) {
+		t.Errorf("downloaded file has hash %x, want %x", sum, want)
+	}
+}
+
+func TestSwarmDropsCorruptProvider(t *testing.T) {
+	data := content(6*config.ChunkSize + 100)
+	bad, entry := share(t, "data.bin", data, true)
+	good, _ := share(t, "data.bin", data, false)
+
+	// The good provider only answers once the bad one was dropped, so every
+	// chunk is offered to the bad one first
+	reporter := newRecorder()
+	gate := make(chan struct{})
+	reporter.onFailure = func(provider string, failures int) {
+		if provider == bad && failures == maxProviderFailures {
+			close(gate)
+		}
+	}
+	gated, _ := relay(t, good, gate, 0)
+
+	c := New(t.TempDir(), logger.Discard)
+	c.Reporter = reporter
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	result, err := c.Download(ctx, Request{
+		Name:      "data.bin",
+		Size:      entry.Size,
+		Hash:      entry.Hash,
+		Root:      entry.Tree.Root().String(),
+		Providers: []string{bad, gated},
+	})
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if result.Attempts != 1 {
+		t.Errorf("Download() took %d attempts, want 1", result.Attempts)
+	}
+	checkDownload(t, result, data)
+
+	if served, failed := reporter.counts(bad); served != 0 || failed != maxProviderFailures {
+		t.Errorf("corrupt provider served %d and failed %d range(s), want 0 and %d", served, failed, maxProviderFailures)
+	}
+	if served, failed := reporter.counts(gated); served != 7 || failed != 0 {
+		t.Errorf("good provider served %d and failed %d range(s), want every chunk", served, failed)
+	}
+}
+
+func TestSwarmFailsOverWhenProviderDies(t *testing.T) {
+	data := content(6*config.ChunkSize + 100)
+	source, entry := share(t, "data.bin", data, false)
+
+	// The dying provider gets through its header and half a chunk, and the
+	// other one only answers after that
+	open := make(chan struct{})
+	close(open)
+	header := int64(3*config.FileSizeLength + config.FileNameLength + config.FileHashLength)
+	dying, died := relay(t, source, open, header+config.ChunkSize/2)
+	other, _ := relay(t, source, died, 0)
+
+	reporter := newRecorder()
+	c := New(t.TempDir(), logger.Discard)
+	c.Reporter = reporter
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	result, err := c.Download(ctx, Request{
+		Name:      "data.bin",
+		Size:      entry.Size,
+		Hash:      entry.Hash,
+		Root:      entry.Tree.Root().String(),
+		Providers: []string{dying, other},
+	})
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if result.Attempts != 1 {
+		t.Errorf("Download() took %d attempts, want 1", result.Attempts)
+	}
+	checkDownload(t, result, data)
+
+	if served, failed := reporter.counts(dying); served != 0 || failed == 0 {
+		t.Errorf("dead provider served %d and failed %d range(s), want only failures", served, failed)
+	}
+	if served, _ := reporter.counts(other); served != 7 {
+		t.Errorf("other provider served %d range(s), want every chunk", served)
+	}
+}
+
+func TestDownloadByHashKeepsLongName(t *testing.T) {
+	// Longer than the name field of the transfer header, and cutting it
+	// there would split a character
+	name := strings.Repeat("é", 40) + ".txt"
+	data := []byte("content")
+	provider, entry := share(t, name, data, false)
+
+	tests := []struct {
+		name  string
+		given string
+		want  string
+	}{
+		{name: "name from the File reply", given: name, want: name},
+		{name: "no name falls back to the hash", want: entry.Hash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			result, err := New(t.TempDir(), logger.Discard).Download(ctx, Request{
+				Name:      tt.given,
+				Size:      entry.Size,
+				Hash:      entry.Hash,
+				Root:      entry.Tree.Root().String(),
+				ByHash:    true,
+				Providers: []string{provider},
+			})
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if result.Name != tt.want || filepath.Base(result.Path) != tt.want {
+				t.Errorf("Download() saved %q as %q, want %q", result.Path, result.Name, tt.want)
+			}
+			checkDownload(t, result, data)
+		})
+	}
+}
+
+// stall passes the first connection it accepts on to target, but only the
+// first limit bytes from target, after which it goes quiet without closing
+// the connection. stalled is closed when it does.
+func stall(t *testing.T, target string, limit int64) (string, <-chan struct{}) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stalled := make(chan struct{})
+	quit := make(chan struct{})
+	t.Cleanup(func() {
+		close(quit)
+		_ = listener.Close()
+	})
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			return
+		}
+		defer func() { _ = upstream.Close() }()
+
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		_, _ = io.CopyN(conn, upstream, limit)
+		close(stalled)
+		<-quit
+	}()
+
+	return listener.Addr().String(), stalled
+}
+
+func TestDownloadCanceledMidTransfer(t *testing.T) {
+	data := content(4 * config.ChunkSize)
+	source, entry := share(t, "data.bin", data, false)
+
+	// A single provider gets the whole file as one range
+	header := int64(3*config.FileSizeLength + config.FileNameLength + config.FileHashLength)
+	provider, stalled := stall(t, source, header+config.ChunkSize)
+
+	reporter := newRecorder()
+	c := New(t.TempDir(), logger.Discard)
+	c.Reporter = reporter
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stalled
+		cancel()
+	}()
+
+	begin := time.Now()
+	result, err := c.Download(ctx, Request{
+		Name:      "data.bin",
+		Size:      entry.Size,
+		Hash:      entry.Hash,
+		Root:      entry.Tree.Root().String(),
+		Providers: []string{provider},
+	})
+	if result != nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download() = %+v, %v, want the context's error", result, err)
+	}
+	if elapsed := time.Since(begin); elapsed >= stallTimeout {
+		t.Errorf("Download() took %v to notice the cancellation", elapsed)
+	}
+	if _, failed := reporter.counts(provider); failed != 0 {
+		t.Errorf("provider failed %d range(s), want the cancellation not held against it", failed)
+	}
+	if _, err := os.Stat(filepath.Join(c.folder, "data.bin")); !os.IsNotExist(err) {
+		t.Errorf("Stat() error = %v, want no downloaded file", err)
+	}
+}
+
+func TestResumeDropsUnverifiedChunks(t *testing.T) {
+	data := content(4*config.ChunkSize + 100)
+	provider, entry := share(t, "data.bin", data, false)
+
+	// An earlier attempt left the first three chunks with their leaf hashes,
+	// but the second one changed on disk since
+	folder := t.TempDir()
+	partial := bytes.Clone(data[:3*config.ChunkSize])
+	partial[config.ChunkSize] ^= 0xff
+	var leaves []byte
+	for pos := 0; pos < 3*config.ChunkSize; pos += config.ChunkSize {
+		hash := merkle.LeafHash(data[pos : pos+config.ChunkSize])
+		leaves = append(leaves, hash[:]...)
+	}
+	path := filepath.Join(folder, index.DownloadingPrefix+entry.Hash)
+	if err := os.WriteFile(path, partial, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+leavesSuffix, leaves, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var resumed []int64
+	result, err := New(folder, logger.Discard).Download(context.Background(), Request{
+		Name:      "data.bin",
+		Size:      entry.Size,
+		Hash:      entry.Hash,
+		Root:      entry.Tree.Root().String(),
+		Providers: []string{provider},
+		Progress:  func(p Progress) { resumed = append(resumed, p.Received) },
+	})
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	checkDownload(t, result, data)
+
+	if len(resumed) == 0 || resumed[0] != config.ChunkSize {
+		t.Errorf("Download() resumed from %v, want only the first chunk kept", resumed)
+	}
+	for _, leftover := range []string{path, path + leavesSuffix} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("Stat(%s) error = %v, want it removed", filepath.Base(leftover), err)
+		}
+	}
+}
+
+func TestDownloadInProgress(t *testing.T) {
+	data := content(4 * config.ChunkSize)
+	source, entry := share(t, "data.bin", data, false)
+
+	header := int64(3*config.FileSizeLength + config.FileNameLength + config.FileHashLength)
+	provider, stalled := stall(t, source, header+config.ChunkSize)
+
+	c := New(t.TempDir(), logger.Discard)
+	req := Request{
+		Name:      "data.bin",
+		Size:      entry.Size,
+		Hash:      entry.Hash,
+		Root:      entry.Tree.Root().String(),
+		Providers: []string{provider},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = c.Download(ctx, req)
+	}()
+	<-stalled
+
+	// The same content under another name would share the partial file
+	copied := req
+	copied.Name = "copy.bin"
+	if result, err := c.Download(context.Background(), copied); result != nil || !errors.Is(err, ErrInProgress) {
+		t.Errorf("Download() = %+v, %v, want ErrInProgress", result, err)
+	}
+
+	cancel()
+	<-done
+}
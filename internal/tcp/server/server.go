@@ -2,35 +2,41 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-
-	"github.com/pterm/pterm"
+	"sync/atomic"
 
 	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/merkle"
 	"github.com/1995parham-teaching/P2P/internal/message"
 )
 
 type Server struct {
 	TCPPort  int
-	folder   string
+	index    *index.Index
 	listener *net.TCPListener
 	host     string
+	log      logger.Logger
+	// uploads is the number of transfers in progress
+	uploads atomic.Int64
 }
 
-func New(folder string, host string) *Server {
+func New(idx *index.Index, host string, log logger.Logger) *Server {
 	return &Server{
-		folder: folder,
-		host:   host,
+		index: idx,
+		host:  host,
+		log:   log,
 	}
 }
 
-// Up starts the TCP server and listens for incoming connections
-func (s *Server) Up(ctx context.Context, tcpPort chan<- int) error {
+// Listen opens the TCP socket on a port the OS picks and returns it
+func (s *Server) Listen() (int, error) {
 	addr := net.TCPAddr{
 		IP:   net.ParseIP(s.host),
 		Port: 0, // Let OS assign a port
@@ -38,13 +44,24 @@ func (s *Server) Up(ctx context.Context, tcpPort chan<- int) error {
 
 	listener, err := net.ListenTCP("tcp", &addr)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	s.listener = listener
 
 	s.TCPPort = listener.Addr().(*net.TCPAddr).Port
-	pterm.Success.Printf("TCP server listening on port %d\n", s.TCPPort)
-	tcpPort <- s.TCPPort
+	s.log.Successf("TCP server listening on port %d", s.TCPPort)
+	return s.TCPPort, nil
+}
+
+// Up accepts incoming connections until ctx is done, listening first unless
+// Listen was called
+func (s *Server) Up(ctx context.Context) error {
+	if s.listener == nil {
+		if _, err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	listener := s.listener
 
 	// Handle graceful shutdown
 	go func() {
@@ -59,7 +76,7 @@ func (s *Server) Up(ctx context.Context, tcpPort chan<- int) error {
 			case <-ctx.Done():
 				return nil // Graceful shutdown
 			default:
-				pterm.Error.Printf("Failed to accept connection: %v\n", err)
+				s.log.Errorf("Failed to accept connection: %v", err)
 				continue
 			}
 		}
@@ -72,40 +89,72 @@ func (s *Server) handleConnection(conn net.Conn) {
 	defer func() { _ = conn.Close() }()
 
 	remoteAddr := conn.RemoteAddr().String()
-	pterm.Info.Printf("TCP connection from %s\n", remoteAddr)
+	s.log.Infof("TCP connection from %s", remoteAddr)
 
 	buffer := make([]byte, config.UDPBufferSize)
 	n, err := conn.Read(buffer)
 	if err != nil {
-		pterm.Error.Printf("Failed to read from %s: %v\n", remoteAddr, err)
+		s.log.Errorf("Failed to read from %s: %v", remoteAddr, err)
 		return
 	}
 
 	msg, err := message.Unmarshal(string(buffer[:n]))
 	if err != nil {
-		pterm.Error.Printf("Failed to unmarshal message from %s: %v\n", remoteAddr, err)
+		s.log.Errorf("Failed to unmarshal message from %s: %v", remoteAddr, err)
 		return
 	}
 
-	getMsg, ok := msg.(*message.Get)
-	if !ok {
-		pterm.Warning.Printf("Expected Get message from %s, got something else\n", remoteAddr)
+	var (
+		entry          index.Entry
+		found          bool
+		offset, length int64
+	)
+
+	// The index only holds base names, which prevents directory traversal.
+	// Requests are served from the index as it is, so peers can't make us
+	// rescan the folder by asking for files we don't have.
+	switch t := msg.(type) {
+	case *message.Get:
+		s.log.Infof("Peer %s requesting file '%s' from offset %d", remoteAddr, t.Name, t.Offset)
+		entry, found = s.index.Get(t.Name)
+		offset, length = t.Offset, t.Length
+	case *message.GetHash:
+		s.log.Infof("Peer %s requesting content %s from offset %d", remoteAddr, t.Hash, t.Offset)
+		entry, found = s.index.GetHash(t.Hash)
+		offset, length = t.Offset, t.Length
+	default:
+		s.log.Warnf("Expected Get or GetHash message from %s, got something else", remoteAddr)
 		return
 	}
 
-	pterm.Info.Printf("Peer %s requesting file '%s'\n", remoteAddr, getMsg.Name)
+	if !found {
+		s.log.Errorf("Requested file is not shared, closing connection to %s", remoteAddr)
+		return
+	}
+
+	s.uploads.Add(1)
+	defer s.uploads.Add(-1)
 
-	if err := s.send(conn, getMsg.Name); err != nil {
-		pterm.Error.Printf("Failed to send file to %s: %v\n", remoteAddr, err)
+	if err := s.send(conn, entry, offset, length); err != nil {
+		s.log.Errorf("Failed to send file to %s: %v", remoteAddr, err)
 	}
 }
 
-func (s *Server) send(conn io.Writer, name string) error {
-	// Use safe path to prevent directory traversal attacks
-	filePath := safePath(s.folder, name)
-	pterm.Debug.Printf("Resolved file path: %s\n", filePath)
+// Load returns the number of uploads in progress
+func (s *Server) Load() int {
+	return int(s.uploads.Load())
+}
+
+// send writes the transfer header followed by length bytes of the file
+// starting at offset. An offset past the end of the file restarts the
+// transfer from zero and a zero length sends everything up to the end.
+// The range is widened to whole ChunkSize chunks, each sent as a one byte
+// proof length, its Merkle proof and the chunk data, so the receiver can
+// verify every chunk against the advertised root as it arrives.
+func (s *Server) send(conn io.Writer, entry index.Entry, offset, length int64) error {
+	s.log.Debugf("Resolved file path: %s", entry.Path)
 
-	file, err := os.Open(filePath)
+	file, err := os.Open(entry.Path)
 	if err != nil {
 		return err
 	}
@@ -116,49 +165,76 @@ func (s *Server) send(conn io.Writer, name string) error {
 		return err
 	}
 
-	fileSize := fillString(strconv.FormatInt(fileInfo.Size(), 10), config.FileSizeLength, ':')
-	fileName := fillString(fileInfo.Name(), config.FileNameLength, ':')
+	// The cached hash only describes the file as it was when looked up
+	if fileInfo.Size() != entry.Size || !fileInfo.ModTime().Equal(entry.ModTime) {
+		return fmt.Errorf("file '%s' changed since it was indexed", entry.Name)
+	}
+
+	size := fileInfo.Size()
+	if offset > size {
+		offset = 0
+	}
+	if length == 0 || offset+length > size {
+		length = size - offset
+	}
 
-	pterm.Info.Printf("Sending file: %s (%d bytes)\n", fileInfo.Name(), fileInfo.Size())
+	// Align the range to chunk boundaries
+	end := offset + length
+	offset -= offset % config.ChunkSize
+	if rem := end % config.ChunkSize; rem != 0 {
+		end = min(end+config.ChunkSize-rem, size)
+	}
+	length = end - offset
 
-	if _, err := conn.Write([]byte(fileSize)); err != nil {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
 		return err
 	}
 
-	if _, err := conn.Write([]byte(fileName)); err != nil {
+	// A name too long for the header is left out rather than cut, which
+	// could split a character. The requester knows it from the File reply.
+	name := fileInfo.Name()
+	if len(name) > config.FileNameLength {
+		name = ""
+	}
+
+	header := fillString(strconv.FormatInt(size, 10), config.FileSizeLength, ':') +
+		fillString(name, config.FileNameLength, ':') +
+		fillString(strconv.FormatInt(offset, 10), config.FileSizeLength, ':') +
+		fillString(strconv.FormatInt(length, 10), config.FileSizeLength, ':') +
+		fillString(entry.Hash, config.FileHashLength, ':')
+
+	s.log.Infof("Sending file: %s (%d of %d bytes from offset %d)", fileInfo.Name(), length, size, offset)
+
+	if _, err := conn.Write([]byte(header)); err != nil {
 		return err
 	}
 
-	// Create progress bar for upload
-	progressBar, _ := pterm.DefaultProgressbar.
-		WithTotal(int(fileInfo.Size())).
-		WithTitle("Uploading " + fileInfo.Name()).
-		WithShowPercentage(true).
-		WithShowElapsedTime(true).
-		Start()
+	sendBuffer := make([]byte, config.ChunkSize)
 
-	sendBuffer := make([]byte, config.BufferSize)
+	for pos := offset; pos < end; pos += config.ChunkSize {
+		chunk := sendBuffer[:min(config.ChunkSize, end-pos)]
 
-	for {
-		n, err := file.Read(sendBuffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			_, _ = progressBar.Stop()
+		if _, err := io.ReadFull(file, chunk); err != nil {
 			return err
 		}
 
-		if _, err := conn.Write(sendBuffer[:n]); err != nil {
-			_, _ = progressBar.Stop()
+		proof := entry.Tree.Proof(int(pos / config.ChunkSize))
+		frame := make([]byte, 0, 1+len(proof)*len(merkle.Hash{}))
+		frame = append(frame, byte(len(proof)))
+		for _, hash := range proof {
+			frame = append(frame, hash[:]...)
+		}
+
+		if _, err := conn.Write(frame); err != nil {
 			return err
 		}
 
-		progressBar.Add(n)
+		if _, err := conn.Write(chunk); err != nil {
+			return err
+		}
 	}
 
-	_, _ = progressBar.Stop()
-	pterm.Success.Println("File sent successfully!")
+	s.log.Successf("Sent %s (%d bytes from offset %d)", fileInfo.Name(), length, offset)
 	return nil
 }
 
@@ -170,12 +246,6 @@ func (s *Server) Close() error {
 	return nil
 }
 
-// safePath ensures the file path is within the allowed folder
-func safePath(folder, filename string) string {
-	safeName := filepath.Base(filename)
-	return filepath.Join(folder, safeName)
-}
-
 // fillString pads a string to the specified length
 func fillString(s string, length int, padding byte) string {
 	if len(s) >= length {
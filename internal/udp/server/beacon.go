@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// Beacon modes
+const (
+	BeaconMulticast = "multicast"
+	BeaconBroadcast = "broadcast"
+)
+
+var ErrInvalidBeaconMode = errors.New("invalid beacon mode")
+
+// Beacon announces the node on the local network so nodes on the same
+// network find each other without an initial cluster list
+type Beacon struct {
+	// Addr is where beacons are sent, the multicast group or the broadcast
+	// address at the beacon port
+	Addr *net.UDPAddr
+	// Group is the multicast group beacons are received on
+	Group  *net.UDPAddr
+	Ticker *time.Ticker
+}
+
+// NewBeacon sends beacons every period to the multicast group or, in
+// broadcast mode, to the broadcast address, both at the given port
+func NewBeacon(mode string, port int, period time.Duration) (*Beacon, error) {
+	group := &net.UDPAddr{IP: net.ParseIP(config.BeaconGroup), Port: port}
+
+	var addr *net.UDPAddr
+	switch mode {
+	case BeaconMulticast:
+		addr = group
+	case BeaconBroadcast:
+		addr = &net.UDPAddr{IP: net.IPv4bcast, Port: port}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidBeaconMode, mode)
+	}
+
+	return &Beacon{
+		Addr:   addr,
+		Group:  group,
+		Ticker: time.NewTicker(period),
+	}, nil
+}
+
+// Announce sends a beacon every beacon period and adds the nodes whose
+// beacons it hears to the cluster. The listener joins the multicast group
+// and also receives broadcasts to the beacon port, so nodes in either mode
+// find each other.
+func (s *Server) Announce(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.ready:
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, s.Beacon.Group)
+	if err != nil {
+		return fmt.Errorf("failed to listen for beacons: %w", err)
+	}
+	s.log.Successf("Listening for beacons on port %d", s.Beacon.Group.Port)
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	go s.listenBeacons(ctx, conn)
+
+	beacon := (&message.Beacon{ID: s.ID}).Marshal()
+	for {
+		// Beacons go out of the node's own socket so their source is the
+		// address the node is reached at
+		s.write(s.Beacon.Addr, beacon)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Beacon.Ticker.C:
+		}
+	}
+}
+
+func (s *Server) listenBeacons(ctx context.Context, conn *net.UDPConn) {
+	buffer := make([]byte, config.UDPBufferSize)
+
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				s.log.Errorf("Beacon read error: %v", err)
+				continue
+			}
+		}
+
+		msg, err := message.Unmarshal(strings.TrimSpace(string(buffer[:n])))
+		if err != nil {
+			s.log.Debugf("Ignoring datagram on the beacon port: %v", err)
+			continue
+		}
+
+		if beacon, ok := msg.(*message.Beacon); ok {
+			s.beacon(beacon, remoteAddr)
+		}
+	}
+}
+
+// beacon joins a node heard on the local network
+func (s *Server) beacon(b *message.Beacon, remoteAddr *net.UDPAddr) {
+	s.join(b.ID, remoteAddr, "a beacon")
+}
+
+// join adds a node found on the local network to the cluster and
+// exchanges state with it, unless it is ourselves or already a member
+func (s *Server) join(id string, addr *net.UDPAddr, how string) {
+	if id == s.ID {
+		return
+	}
+	if _, ok := s.Cluster.LookupID(id); ok {
+		return
+	}
+	if _, ok := s.Cluster.Lookup(addr.String()); ok {
+		return
+	}
+
+	s.log.Infof("Found %s through %s", addr.String(), how)
+	s.Cluster.Add(addr.String())
+	s.sync(addr, true)
+}
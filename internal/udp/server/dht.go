@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/cluster"
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/dht"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// Publish stores provider records for the shared files in the DHT, under
+// the hash of every file's name and of its content, at the nodes closest
+// to each key. Records are republished well before they expire, which also
+// picks up files added since.
+func (s *Server) Publish(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-s.ready:
+	}
+
+	// Give joining the cluster a moment to fill the routing table
+	timer := time.NewTimer(config.DHTPublishDelay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	ticker := time.NewTicker(config.DHTRepublishPeriod)
+	defer ticker.Stop()
+
+	for {
+		s.publish(ctx)
+		s.providers.Expire(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) publish(ctx context.Context) {
+	s.refreshTable()
+	if s.table.Len() == 0 {
+		return
+	}
+
+	s.index.Rebuild()
+	entries := s.index.Entries()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		s.publishKey(ctx, dht.NameKey(entry.Name))
+		s.publishKey(ctx, dht.ContentKey(entry.Hash))
+	}
+
+	s.log.Debugf("Published provider records for %d file(s) in the DHT", len(entries))
+}
+
+// PublishFile stores provider records for a single file right away, e.g.
+// one we just downloaded, instead of leaving it to the next republish
+func (s *Server) PublishFile(ctx context.Context, name string, hash string) {
+	s.refreshTable()
+	if s.table.Len() == 0 {
+		return
+	}
+
+	s.publishKey(ctx, dht.NameKey(name))
+	s.publishKey(ctx, dht.ContentKey(hash))
+	s.log.Debugf("Published provider records for '%s' in the DHT", name)
+}
+
+// publishKey stores a provider record for key at the nodes closest to it
+func (s *Server) publishKey(ctx context.Context, key dht.Key) {
+	result := dht.Lookup(ctx, s.table, key, config.DHTBucketSize, config.DHTConcurrency, false, s.lookupQuery(key))
+
+	msg := (&message.Provide{From: s.ID, Key: key.String()}).Marshal()
+	for _, c := range result.Closest {
+		if addr, err := net.ResolveUDPAddr("udp", c.Addr); err == nil {
+			s.write(addr, msg)
+		}
+	}
+
+	// We may be among the closest nodes ourselves
+	if len(result.Closest) < config.DHTBucketSize || key.Closer(s.table.Self(), result.Closest[len(result.Closest)-1].ID) {
+		s.providers.Add(key, s.Cluster.Self().Addr, time.Now().Add(config.DHTProviderTTL))
+	}
+}
+
+// findProviders looks up the providers of key in the DHT and returns their
+// UDP addresses. Records outlive the nodes that published them, so only
+// providers that are alive members are returned, ourselves left out.
+func (s *Server) findProviders(ctx context.Context, key dht.Key) []string {
+	s.refreshTable()
+
+	result := dht.Lookup(ctx, s.table, key, config.DHTBucketSize, config.DHTConcurrency, true, s.lookupQuery(key))
+	s.log.Debugf("DHT lookup for %s sent %d queries", key, result.Queries)
+
+	self := s.Cluster.Self().Addr
+	providers := make([]string, 0, len(result.Providers))
+	for _, addr := range result.Providers {
+		if addr == self {
+			continue
+		}
+		if p, ok := s.Cluster.Lookup(addr); !ok || p.State != message.Alive {
+			s.log.Debugf("Skipping provider %s of %s, not an alive member", addr, key)
+			continue
+		}
+		providers = append(providers, addr)
+	}
+	return providers
+}
+
+// lookupQuery sends a Lookup for key to a DHT node and waits for its Found
+func (s *Server) lookupQuery(key dht.Key) dht.Query {
+	return func(ctx context.Context, c dht.Contact) (dht.Reply, error) {
+		addr, err := net.ResolveUDPAddr("udp", c.Addr)
+		if err != nil {
+			return dht.Reply{}, fmt.Errorf("failed to resolve address %s: %w", c.Addr, err)
+		}
+
+		nonce, req, done := s.register(key.String())
+		defer done()
+
+		s.write(addr, (&message.Lookup{Nonce: nonce, From: s.ID, Key: key.String()}).Marshal())
+
+		timeout := time.NewTimer(config.DHTQueryTimeout)
+		defer timeout.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return dht.Reply{}, ctx.Err()
+			case <-timeout.C:
+				return dht.Reply{}, fmt.Errorf("node %s did not answer within %v", c.Addr, config.DHTQueryTimeout)
+			case r := <-req.replies:
+				found, ok := r.msg.(*message.Found)
+				if !ok {
+					continue
+				}
+
+				reply := dht.Reply{Providers: found.Providers}
+				for _, contact := range found.Contacts {
+					if id, err := dht.ParseKey(contact.ID); err == nil {
+						reply.Contacts = append(reply.Contacts, dht.Contact{ID: id, Addr: contact.Addr})
+					}
+				}
+				return reply, nil
+			}
+		}
+	}
+}
+
+// found answers a Lookup with the providers we store for the key and the
+// nodes we know closest to it
+func (s *Server) found(lookup *message.Lookup) *message.Found {
+	found := &message.Found{Nonce: lookup.Nonce, From: s.ID}
+
+	key, err := dht.ParseKey(lookup.Key)
+	if err != nil {
+		return found
+	}
+
+	found.Providers = s.providers.Get(key, time.Now(), config.DHTMaxProviders)
+	for _, c := range s.table.Closest(key, config.DHTBucketSize) {
+		found.Contacts = append(found.Contacts, message.Contact{ID: c.ID.String(), Addr: c.Addr})
+	}
+	return found
+}
+
+// provide stores a provider record for the sender of a Provide
+func (s *Server) provide(p *message.Provide, remoteAddr *net.UDPAddr) {
+	key, err := dht.ParseKey(p.Key)
+	if err != nil {
+		s.log.Debugf("Ignoring provider record from %s: %v", remoteAddr.String(), err)
+		return
+	}
+	s.providers.Add(key, remoteAddr.String(), time.Now().Add(config.DHTProviderTTL))
+}
+
+// contact adds the sender of a DHT message to the routing table
+func (s *Server) contact(id string, remoteAddr *net.UDPAddr) {
+	if key, err := dht.ParseKey(id); err == nil {
+		s.table.Add(dht.Contact{ID: key, Addr: remoteAddr.String()})
+	}
+}
+
+// refreshTable brings the routing table in line with the cluster: alive
+// members join it and members that are gone leave it
+func (s *Server) refreshTable() {
+	members := make(map[dht.Key]bool)
+	for _, p := range s.Cluster.Select(func(p cluster.Peer) bool { return p.State != message.Dead }) {
+		if key, err := dht.ParseKey(p.ID); err == nil {
+			members[key] = true
+			s.table.Add(dht.Contact{ID: key, Addr: p.Addr})
+		}
+	}
+
+	for _, c := range s.table.Contacts() {
+		if !members[c.ID] {
+			s.table.Remove(c.ID)
+		}
+	}
+}
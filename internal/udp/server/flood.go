@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// routes remembers the file requests this node has seen, so a request that
+// comes around again is dropped, and the peer each one came from, so its
+// File replies are relayed back along the path it took
+type routes struct {
+	entries map[string]route
+	mutex   sync.Mutex
+}
+
+type route struct {
+	// from is the previous hop, nil for our own requests
+	from    *net.UDPAddr
+	expires time.Time
+}
+
+func newRoutes() *routes {
+	return &routes{entries: make(map[string]route)}
+}
+
+// add records request id as coming from the given peer and reports whether
+// it is new
+func (r *routes) add(id string, from *net.UDPAddr, now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, rt := range r.entries {
+		if !now.Before(rt.expires) {
+			delete(r.entries, key)
+		}
+	}
+
+	if _, ok := r.entries[id]; ok {
+		return false
+	}
+	r.entries[id] = route{from: from, expires: now.Add(config.RouteTimeout)}
+	return true
+}
+
+// previous returns the peer request id came from, if we forwarded it
+func (r *routes) previous(id string, now time.Time) (*net.UDPAddr, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rt, ok := r.entries[id]
+	if !ok || rt.from == nil || !now.Before(rt.expires) {
+		return nil, false
+	}
+	return rt.from, true
+}
+
+// forward passes a file request on to the members other than the peer it
+// came from and its originator, with one hop less to go. A request without
+// an origin comes straight from the peer that sent it.
+func (s *Server) forward(id string, ttl int, origin string, from *net.UDPAddr, msg func(ttl int, origin string) string) {
+	ttl = min(ttl, config.MaxSearchTTL) - 1
+	if ttl < 1 {
+		return
+	}
+	if origin == "" {
+		origin = from.String()
+	}
+
+	forwarded := msg(ttl, origin)
+	sent := 0
+	for _, member := range s.Cluster.List() {
+		addr, err := net.ResolveUDPAddr("udp", member)
+		if err != nil {
+			continue
+		}
+		if addr.String() == from.String() || addr.String() == origin {
+			continue
+		}
+		s.write(addr, forwarded)
+		sent++
+	}
+
+	if sent > 0 {
+		s.log.Debugf("Forwarded file request %s from %s to %d peer(s), TTL %d", id, origin, sent, ttl)
+	}
+}
+
+// backtrack relays a File reply to the peer we got the request from. The
+// first hop fills in the address of the node that has the file, which the
+// requester can't learn from the relayed datagram.
+func (s *Server) backtrack(file *message.File, from *net.UDPAddr, to *net.UDPAddr) {
+	if file.Addr == "" {
+		file.Addr = net.JoinHostPort(from.IP.String(), strconv.Itoa(file.TCPPort))
+	}
+
+	s.log.Debugf("Relaying File reply %s from %s back to %s", file.ID, file.Addr, to.String())
+	s.write(to, file.Marshal())
+}
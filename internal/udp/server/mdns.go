@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/identity"
+	"github.com/1995parham-teaching/P2P/internal/mdns"
+)
+
+// Advertise announces the node as a _p2p._udp DNS-SD service over mDNS and
+// joins the other nodes it finds that way. The node is found by hostname
+// as p2p-<id prefix>.local, and its TXT record carries its ID, TCP port and
+// protocol version. A node listening on the loopback address can't be
+// reached by the nodes it would find, so it neither advertises nor browses.
+func (s *Server) Advertise(ctx context.Context) error {
+	if ip := net.ParseIP(s.IP); ip != nil && ip.IsLoopback() {
+		s.log.Warnf("Not using mDNS, the node only listens on the loopback address %s", s.IP)
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.ready:
+	}
+
+	self := s.Cluster.Self()
+	name := "p2p-" + s.ID[:12]
+	service := &mdns.Service{
+		Instance: name,
+		Host:     name,
+		IPs:      mdns.LocalIPs(s.IP),
+		Port:     s.Port,
+		Text: []string{
+			"id=" + s.ID,
+			"tcp=" + strconv.Itoa(self.TCPPort),
+			"version=" + strconv.Itoa(config.ProtocolVersion),
+		},
+	}
+
+	return mdns.Serve(ctx, service, config.MDNSQueryPeriod, s.log, func(entry mdns.Entry, from *net.UDPAddr) {
+		id := entry.Text["id"]
+		if !identity.Valid(id) {
+			return
+		}
+
+		// Prefer the advertised address, the source is the responder's
+		ip := from.IP
+		for _, addr := range entry.IPs {
+			if addr.To4() != nil {
+				ip = addr
+				break
+			}
+		}
+		s.join(id, &net.UDPAddr{IP: ip, Port: entry.Port}, "mDNS")
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/cluster"
+	"github.com/1995parham-teaching/P2P/internal/reputation"
+)
+
+// Transferred credits the peer behind a TCP provider address with a range
+// it served, the server is the TCP client's reporter
+func (s *Server) Transferred(provider string, bytes int64, elapsed time.Duration) {
+	s.Scores.Transfer(s.providerKey(provider), bytes, elapsed, time.Now())
+}
+
+// Failed counts a range the peer behind a TCP provider address failed
+func (s *Server) Failed(provider string) {
+	s.Scores.Failure(s.providerKey(provider), time.Now())
+}
+
+// peerKey returns what a peer is scored under given its UDP address: its
+// node ID when we know it, the address otherwise
+func (s *Server) peerKey(addr string) string {
+	if p, ok := s.Cluster.Lookup(addr); ok && p.ID != "" {
+		return p.ID
+	}
+	return addr
+}
+
+// providerKey returns what a peer is scored under given the address of its
+// TCP server: the ID of the member on that host advertising that port when
+// there is one, the address otherwise
+func (s *Server) providerKey(provider string) string {
+	host, port, err := net.SplitHostPort(provider)
+	if err != nil {
+		return provider
+	}
+
+	peers := s.Cluster.Select(func(p cluster.Peer) bool {
+		if p.ID == "" || strconv.Itoa(p.TCPPort) != port {
+			return false
+		}
+		for _, addr := range p.Addrs {
+			if h, _, err := net.SplitHostPort(addr); err == nil && h == host {
+				return true
+			}
+		}
+		return false
+	})
+	if len(peers) == 1 {
+		return peers[0].ID
+	}
+	return provider
+}
+
+// offer describes a provider by its TCP address, with its last measured RTT
+// when it is a member we pinged
+func (s *Server) offer(provider string, load int) reputation.Offer {
+	key := s.providerKey(provider)
+	offer := reputation.Offer{Addr: provider, Load: load, Score: s.Scores.Score(key, time.Now())}
+	if p, ok := s.Cluster.LookupID(key); ok && p.Reachable {
+		offer.RTT = p.RTT
+	}
+	return offer
+}
+
+// load returns the number of uploads in progress
+func (s *Server) load() int {
+	if s.Load == nil {
+		return 0
+	}
+	return s.Load()
+}
@@ -2,68 +2,130 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/pterm/pterm"
-
 	"github.com/1995parham-teaching/P2P/internal/cluster"
 	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/dht"
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/logger"
 	"github.com/1995parham-teaching/P2P/internal/message"
+	"github.com/1995parham-teaching/P2P/internal/reputation"
+	"github.com/1995parham-teaching/P2P/internal/tcp/client"
 )
 
+// ErrNotFound is returned when no peer has the requested file
+var ErrNotFound = errors.New("file not found")
+
 type Server struct {
 	IP              string
 	Port            int
 	Cluster         *cluster.Cluster
 	DiscoveryTicker *time.Ticker
+	ProbeTicker     *time.Ticker
 	waitingDuration time.Duration
-	Req             string
-	folder          string
+	collectDuration time.Duration
+	index           *index.Index
 	conn            *net.UDPConn
+	log             logger.Logger
+
+	// Outstanding file requests keyed by request ID
+	searches      map[string]*search
+	searchesMutex sync.Mutex
+
+	// ID identifies this node to the other members
+	ID string
+	// Beacon announces the node on the local network, nil when disabled
+	Beacon *Beacon
+	// MDNS advertises the node as a DNS-SD service over mDNS
+	MDNS bool
+	// TCPPort is the port our TCP server listens on, which File replies
+	// and our meta advertise. It must be set before Up.
+	TCPPort int
+	// TTL is how many hops a broadcast file request travels, 1 keeps it to
+	// the direct members
+	TTL int
+	// Scores rates the peers that served us files, and ResponseDelay is how
+	// long a request from a peer without any score waits for our answer
+	Scores        *reputation.Scores
+	ResponseDelay time.Duration
+	// Load, if set, returns the number of uploads in progress, which File
+	// replies advertise
+	Load func() int
+
+	// Requests seen and the peers to relay their replies back to
+	routes *routes
+
+	// DHT routing table and the provider records stored at this node
+	table     *dht.Table
+	providers *dht.Providers
+
+	// ready is closed once the socket is listening
+	ready chan struct{}
+}
 
-	// File request state
-	waiting       bool
-	waitingMutex  sync.Mutex
-	waitingCancel context.CancelFunc
+// search is an outstanding request collecting its replies
+type search struct {
+	name    string
+	replies chan reply
+}
 
-	// Priority responders tracking
-	prior      []string
-	priorMutex sync.RWMutex
+// reply is a reply message together with the peer that sent it
+type reply struct {
+	msg  message.Message
+	addr *net.UDPAddr
+}
+
+// file returns the reply as a File message, if it is one
+func (r reply) file() (*message.File, bool) {
+	f, ok := r.msg.(*message.File)
+	return f, ok
+}
 
-	// File index cache
-	fileIndex      map[string]string // filename -> full path
-	fileIndexMutex sync.RWMutex
+// Hit is a file a peer listed in reply to a search query
+type Hit struct {
+	Peer string
+	message.Match
 }
 
-func New(ip string, port int, cluster *cluster.Cluster,
-	ticker *time.Ticker, waitingDuration int, folder string) *Server {
-	s := &Server{
+func New(id string, ip string, port int, cluster *cluster.Cluster, ticker *time.Ticker, probeTicker *time.Ticker,
+	waitingDuration time.Duration, collectDuration time.Duration, idx *index.Index, log logger.Logger) *Server {
+	// Node IDs double as DHT keys
+	self, err := dht.ParseKey(id)
+	if err != nil {
+		self = dht.NameKey(id)
+	}
+
+	return &Server{
 		IP:              ip,
 		Port:            port,
 		Cluster:         cluster,
 		DiscoveryTicker: ticker,
-		waitingDuration: time.Duration(waitingDuration) * time.Second,
-		folder:          folder,
-		prior:           make([]string, 0),
-		fileIndex:       make(map[string]string),
+		ProbeTicker:     probeTicker,
+		waitingDuration: waitingDuration,
+		collectDuration: collectDuration,
+		index:           idx,
+		log:             log,
+		searches:        make(map[string]*search),
+		ID:              id,
+		table:           dht.NewTable(self, config.DHTBucketSize),
+		providers:       dht.NewProviders(),
+		routes:          newRoutes(),
+		TTL:             1,
+		Scores:          reputation.New(config.ScoreHalfLife),
+		ready:           make(chan struct{}),
 	}
-
-	// Build initial file index
-	s.rebuildFileIndex()
-
-	return s
 }
 
-// Up starts the UDP server and listens for incoming messages
-func (s *Server) Up(ctx context.Context, tcpPort <-chan int, request chan<- string, fName chan<- string) error {
-	tPort := <-tcpPort
-
+// Listen opens the UDP socket, after which requests can be sent. TCPPort
+// must be set before.
+func (s *Server) Listen() error {
 	addr := net.UDPAddr{
 		IP:   net.ParseIP(s.IP),
 		Port: s.Port,
@@ -74,7 +136,28 @@ func (s *Server) Up(ctx context.Context, tcpPort <-chan int, request chan<- stri
 		return fmt.Errorf("failed to start UDP server: %w", err)
 	}
 	s.conn = conn
-	pterm.Success.Printf("UDP server listening on %s:%d\n", s.IP, s.Port)
+	s.log.Successf("UDP server listening on %s:%d", s.IP, s.Port)
+
+	s.Cluster.SetSelf(fmt.Sprintf("%s:%d", s.IP, s.Port), message.Meta{
+		ID:           s.ID,
+		TCPPort:      s.TCPPort,
+		Version:      config.ProtocolVersion,
+		Capabilities: message.CapSearch | message.CapBrowse | message.CapContent | message.CapSwarm,
+	})
+	s.index.SetNotify(s.resummarize)
+	close(s.ready)
+	return nil
+}
+
+// Up listens for incoming messages until ctx is done, opening the socket
+// first unless Listen was called
+func (s *Server) Up(ctx context.Context) error {
+	if s.conn == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	conn := s.conn
 
 	// Handle graceful shutdown
 	go func() {
@@ -91,240 +174,707 @@ func (s *Server) Up(ctx context.Context, tcpPort <-chan int, request chan<- stri
 			case <-ctx.Done():
 				return nil
 			default:
-				pterm.Error.Printf("UDP read error: %v\n", err)
+				s.log.Errorf("UDP read error: %v", err)
 				continue
 			}
 		}
 
 		// Parse the message
 		msgStr := strings.TrimSpace(string(buffer[:n]))
-		pterm.Debug.Printf("Received: %s\n", msgStr)
+		s.log.Debugf("Received: %s", msgStr)
 
 		msg, err := message.Unmarshal(msgStr)
 		if err != nil {
-			pterm.Error.Printf("Failed to unmarshal message: %v\n", err)
+			s.log.Errorf("Failed to unmarshal message: %v", err)
 			continue
 		}
 
-		s.handleMessage(ctx, msg, remoteAddr, tPort, request, fName)
+		s.Cluster.Seen(remoteAddr.String())
+		s.handleMessage(msg, remoteAddr)
 	}
 }
 
-func (s *Server) handleMessage(ctx context.Context, msg message.Message, remoteAddr *net.UDPAddr, tcpPort int, request chan<- string, fName chan<- string) {
-	pterm.Debug.Println("Processing message")
+func (s *Server) handleMessage(msg message.Message, remoteAddr *net.UDPAddr) {
+	s.log.Debugf("Processing message")
 
 	switch t := msg.(type) {
-	case *message.Discover:
-		host := fmt.Sprintf("%s:%d", s.IP, s.Port)
-		pterm.Debug.Printf("Received discovery from %s with %d peer(s)\n", remoteAddr.String(), len(t.List))
-		s.Cluster.Merge(host, t.List)
+	case *message.Sync:
+		s.log.Debugf("Received %d membership update(s) from %s", len(t.Updates), remoteAddr.String())
+		s.Cluster.Apply(t.Updates)
+		s.Cluster.Identify(remoteAddr.String(), t.From)
+		if t.Reply {
+			s.sync(remoteAddr, false)
+		}
+
+	case *message.Summary:
+		s.summarize(t, remoteAddr)
+
+	case *message.Beacon:
+		s.beacon(t, remoteAddr)
 
 	case *message.Get:
-		pterm.Info.Printf("Peer %s is requesting file '%s'\n", remoteAddr.String(), t.Name)
-		if s.Search(t.Name) {
-			pterm.Success.Printf("File '%s' found locally, responding to %s\n", t.Name, remoteAddr.String())
-			go s.transfer(remoteAddr, (&message.File{
-				Method:  config.TransferMethodTCP,
-				TCPPort: tcpPort,
-			}).Marshal())
+		if !s.routes.add(t.ID, remoteAddr, time.Now()) {
+			s.log.Debugf("Dropping file request %s from %s, seen before", t.ID, remoteAddr.String())
+			return
+		}
+
+		// Answered from the index as it is, a miss mustn't make us rescan the
+		// folder on the read loop
+		s.log.Infof("Peer %s is requesting file '%s'", remoteAddr.String(), t.Name)
+		if entry, ok := s.index.Get(t.Name); ok {
+			s.log.Successf("File '%s' found locally, responding to %s", t.Name, remoteAddr.String())
+			go s.transfer(remoteAddr, fileReply(t.ID, s.TCPPort, s.load(), entry).Marshal())
 		} else {
-			pterm.Debug.Printf("File '%s' not found locally\n", t.Name)
+			s.log.Debugf("File '%s' not found locally", t.Name)
 		}
 
-	case *message.File:
-		s.waitingMutex.Lock()
-		isWaiting := s.waiting
-		s.waitingMutex.Unlock()
+		s.forward(t.ID, t.TTL, t.Origin, remoteAddr, func(ttl int, origin string) string {
+			get := *t
+			get.TTL, get.Origin = ttl, origin
+			return get.Marshal()
+		})
 
-		if isWaiting {
-			pterm.Success.Printf("Peer %s has file '%s' (TCP port: %d)\n", remoteAddr.IP.String(), s.Req, t.TCPPort)
+	case *message.GetHash:
+		if !s.routes.add(t.ID, remoteAddr, time.Now()) {
+			s.log.Debugf("Dropping file request %s from %s, seen before", t.ID, remoteAddr.String())
+			return
+		}
 
-			// Add to prior list
-			s.addToPrior(remoteAddr.String())
+		s.log.Infof("Peer %s is requesting content %s", remoteAddr.String(), t.Hash)
+		if entry, ok := s.index.GetHash(t.Hash); ok {
+			s.log.Successf("Content %s found locally as '%s', responding to %s", t.Hash, entry.Name, remoteAddr.String())
+			// The requester only knows the hash and saves the file under our name
+			reply := fileReply(t.ID, s.TCPPort, s.load(), entry)
+			reply.Name = entry.Name
+			go s.transfer(remoteAddr, reply.Marshal())
+		} else {
+			s.log.Debugf("Content %s not found locally", t.Hash)
+		}
 
-			// Cancel waiting
-			s.waitingMutex.Lock()
-			s.waiting = false
-			if s.waitingCancel != nil {
-				s.waitingCancel()
-			}
-			s.waitingMutex.Unlock()
+		s.forward(t.ID, t.TTL, t.Origin, remoteAddr, func(ttl int, origin string) string {
+			getHash := *t
+			getHash.TTL, getHash.Origin = ttl, origin
+			return getHash.Marshal()
+		})
 
-			ip := remoteAddr.IP.String()
-			serverAddr := fmt.Sprintf("%s:%d", ip, t.TCPPort)
-			pterm.Info.Printf("Initiating TCP download from %s\n", serverAddr)
-			request <- serverAddr
-			fName <- s.Req
+	case *message.File:
+		if previous, ok := s.routes.previous(t.ID, time.Now()); ok {
+			s.backtrack(t, remoteAddr, previous)
 		} else {
-			pterm.Debug.Printf("Received late file response from %s (no longer waiting)\n", remoteAddr.String())
+			s.deliver(t.ID, t, remoteAddr)
 		}
+
+	case *message.Search:
+		s.log.Infof("Peer %s is searching for '%s'", remoteAddr.String(), t.Query)
+		s.write(remoteAddr, s.resultReply(t).Marshal())
+
+	case *message.Result:
+		s.deliver(t.ID, t, remoteAddr)
+
+	case *message.List:
+		s.log.Debugf("Peer %s is browsing page %d of our files", remoteAddr.String(), t.Page)
+		s.write(remoteAddr, s.catalogReply(t).Marshal())
+
+	case *message.Catalog:
+		s.deliver(t.ID, t, remoteAddr)
+
+	case *message.Ping:
+		s.Cluster.Apply(t.Updates)
+		pong := &message.Pong{Nonce: t.Nonce, Timestamp: t.Timestamp, Updates: s.Cluster.Gossip(config.MaxPiggyback)}
+		s.write(remoteAddr, pong.Marshal())
+
+	case *message.Pong:
+		s.Cluster.Apply(t.Updates)
+		s.deliver(t.Nonce, t, remoteAddr)
+
+	case *message.PingReq:
+		s.Cluster.Apply(t.Updates)
+		go s.relay(remoteAddr, t)
+
+	case *message.Lookup:
+		s.contact(t.From, remoteAddr)
+		s.write(remoteAddr, s.found(t).Marshal())
+
+	case *message.Found:
+		s.contact(t.From, remoteAddr)
+		s.deliver(t.Nonce, t, remoteAddr)
+
+	case *message.Provide:
+		s.contact(t.From, remoteAddr)
+		s.provide(t, remoteAddr)
+	}
+}
+
+// register adds an outstanding request to the table and returns its ID
+// along with a function that removes it again
+func (s *Server) register(name string) (string, *search, func()) {
+	id := message.NewID()
+	req := &search{
+		name:    name,
+		replies: make(chan reply, config.MaxFileReplies),
+	}
+
+	s.searchesMutex.Lock()
+	s.searches[id] = req
+	s.searchesMutex.Unlock()
+
+	return id, req, func() {
+		s.searchesMutex.Lock()
+		delete(s.searches, id)
+		s.searchesMutex.Unlock()
+	}
+}
+
+// deliver hands a reply to the outstanding request with the given ID
+func (s *Server) deliver(id string, msg message.Message, remoteAddr *net.UDPAddr) {
+	s.searchesMutex.Lock()
+	req, ok := s.searches[id]
+	s.searchesMutex.Unlock()
+
+	if !ok {
+		s.log.Debugf("Received late response %s from %s (no longer waiting)", id, remoteAddr.String())
+		return
+	}
+
+	select {
+	case req.replies <- reply{msg: msg, addr: remoteAddr}:
+	default:
+		s.log.Debugf("Ignoring response %s from %s, too many replies", id, remoteAddr.String())
+	}
+}
+
+// resultReply lists the local files matching a search, as many as fit in
+// a single datagram. It answers from the index as it is, the folder is
+// scanned on a timer, so searches can't make us walk it.
+func (s *Server) resultReply(search *message.Search) *message.Result {
+	matches := toMatches(s.index.Match(search.Query))
+	pages := message.Paginate(matches, config.UDPBufferSize-config.MessageHeaderSize)
+	if len(pages) > 1 {
+		s.log.Debugf("Search results for '%s' truncated to %d file(s)", search.Query, len(pages[0]))
+	}
+
+	return &message.Result{ID: search.ID, Matches: pages[0]}
+}
+
+// catalogReply returns the requested page of the local files. Pages are
+// cut from the sorted index, so they stay stable while it doesn't change.
+func (s *Server) catalogReply(list *message.List) *message.Catalog {
+	matches := toMatches(s.index.Entries())
+	pages := message.Paginate(matches, config.UDPBufferSize-config.MessageHeaderSize)
+
+	catalog := &message.Catalog{ID: list.ID, Page: list.Page, Pages: len(pages)}
+	if list.Page < len(pages) {
+		catalog.Matches = pages[list.Page]
+	}
+
+	return catalog
+}
+
+// toMatches converts index entries into their wire representation
+func toMatches(entries []index.Entry) []message.Match {
+	matches := make([]message.Match, 0, len(entries))
+	for _, entry := range entries {
+		matches = append(matches, message.Match{Name: entry.Name, Size: entry.Size, Hash: entry.Hash})
 	}
+	return matches
 }
 
+// fileReply builds the File message announcing a local entry
+func fileReply(id string, tcpPort int, load int, entry index.Entry) *message.File {
+	return &message.File{
+		ID:      id,
+		Method:  config.TransferMethodTCP,
+		TCPPort: tcpPort,
+		Load:    load,
+		Size:    entry.Size,
+		Hash:    entry.Hash,
+		Root:    entry.Tree.Root().String(),
+	}
+}
+
+// transfer answers a file request from addr after a delay that is the
+// shorter the better addr's score. The origin a forwarded request names is
+// up to its sender, so the peer it came from is scored, not the origin.
 func (s *Server) transfer(addr *net.UDPAddr, msg string) {
-	// Check if this is a priority responder
-	isPrior := s.isPrior(addr.String())
-	if !isPrior {
-		pterm.Info.Printf("Waiting %v before responding (non-priority peer)...\n", config.NonPriorResponseDelay)
-		time.Sleep(config.NonPriorResponseDelay)
+	score := s.Scores.Score(s.peerKey(addr.String()), time.Now())
+	if delay := reputation.Delay(score, s.ResponseDelay); delay > 0 {
+		s.log.Infof("Waiting %v before responding to %s (score %.2f)...", delay.Round(time.Millisecond), addr.String(), score)
+		time.Sleep(delay)
 	} else {
-		pterm.Info.Println("Responding immediately (priority peer)")
+		s.log.Infof("Responding immediately to %s (score %.2f)", addr.String(), score)
 	}
 
-	pterm.Info.Printf("Sending file response to %s\n", addr.String())
+	s.log.Infof("Sending file response to %s", addr.String())
 	if _, err := s.conn.WriteToUDP([]byte(msg), addr); err != nil {
-		pterm.Error.Printf("Failed to send transfer message: %v\n", err)
+		s.log.Errorf("Failed to send transfer message: %v", err)
 	} else {
-		pterm.Success.Printf("File response sent to %s\n", addr.String())
+		s.log.Successf("File response sent to %s", addr.String())
 	}
 }
 
-// Discover periodically broadcasts cluster information
-func (s *Server) Discover(ctx context.Context) {
+// write sends a message to a single peer right away
+func (s *Server) write(addr *net.UDPAddr, msg string) {
+	if _, err := s.conn.WriteToUDP([]byte(msg), addr); err != nil {
+		s.log.Errorf("Failed to send message to %s: %v", addr.String(), err)
+	}
+}
+
+// Gossip runs the membership protocol. It first exchanges the full state
+// with the initial members to join the cluster, then probes one member per
+// probe period and exchanges the full state with one random member per
+// discovery period to repair anything the piggybacked gossip missed.
+func (s *Server) Gossip(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-s.ready:
+	}
+
+	for _, peer := range s.Cluster.List() {
+		if addr, err := net.ResolveUDPAddr("udp", peer); err == nil {
+			s.sync(addr, true)
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-s.ProbeTicker.C:
+			for _, addr := range s.Cluster.Check(time.Now()) {
+				s.log.Warnf("Peer %s did not refute the suspicion, declared dead", addr)
+			}
+			s.probe(ctx)
 		case <-s.DiscoveryTicker.C:
-			list := s.Cluster.List()
-			msg := (&message.Discover{List: list}).Marshal()
-			if err := s.Cluster.Broadcast(s.conn, msg); err != nil {
-				pterm.Error.Printf("Discovery broadcast error: %v\n", err)
+			// Pick up shared files added or changed since, for the summary
+			// and searches
+			s.index.Rebuild()
+			for _, peer := range s.Cluster.Random(1, "") {
+				if addr, err := net.ResolveUDPAddr("udp", peer); err == nil {
+					s.sync(addr, true)
+				}
 			}
 		}
 	}
 }
 
-// File broadcasts a file request to the cluster
-func (s *Server) File(ctx context.Context) {
-	s.waitingMutex.Lock()
-	s.waiting = true
-	waitCtx, cancel := context.WithTimeout(ctx, s.waitingDuration)
-	s.waitingCancel = cancel
-	s.waitingMutex.Unlock()
+// probe pings the next member in the probe order. If it doesn't answer,
+// other members are asked to ping it, and only when none of them gets an
+// answer either it is suspected.
+func (s *Server) probe(ctx context.Context) {
+	target, ok := s.Cluster.NextProbe()
+	if !ok {
+		return
+	}
 
-	clusterSize := len(s.Cluster.List())
-	pterm.Info.Printf("Broadcasting file request for '%s' to %d peer(s)\n", s.Req, clusterSize)
+	if _, err := s.pingWithin(ctx, target, config.ProbeTimeout); err == nil {
+		return
+	}
 
-	msg := (&message.Get{Name: s.Req}).Marshal()
-	if err := s.Cluster.Broadcast(s.conn, msg); err != nil {
-		pterm.Error.Printf("File request broadcast error: %v\n", err)
+	helpers := s.Cluster.Random(config.IndirectProbes, target)
+	if len(helpers) == 0 {
+		s.Cluster.Suspect(target)
+		return
 	}
 
-	// Wait for timeout or response
-	<-waitCtx.Done()
+	nonce, req, done := s.register(target)
+	defer done()
 
-	s.waitingMutex.Lock()
-	wasWaiting := s.waiting
-	s.waiting = false
-	s.waitingCancel = nil
-	s.waitingMutex.Unlock()
+	s.log.Debugf("Peer %s missed a probe, asking %d member(s) to ping it", target, len(helpers))
+	for _, helper := range helpers {
+		addr, err := net.ResolveUDPAddr("udp", helper)
+		if err != nil {
+			continue
+		}
+		msg := &message.PingReq{
+			Nonce:     nonce,
+			Timestamp: time.Now().UnixNano(),
+			Target:    target,
+			Updates:   s.Cluster.Gossip(config.MaxPiggyback),
+		}
+		s.write(addr, msg.Marshal())
+	}
+
+	timeout := time.NewTimer(config.IndirectProbeTimeout)
+	defer timeout.Stop()
 
-	if wasWaiting {
-		pterm.Warning.Printf("No peer responded with file '%s' (timeout after %v)\n", s.Req, s.waitingDuration)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			s.Cluster.Suspect(target)
+			return
+		case r := <-req.replies:
+			if _, ok := r.msg.(*message.Pong); ok {
+				return
+			}
+		}
 	}
 }
 
-// Search checks if a file exists in the shared folder
-func (s *Server) Search(filename string) bool {
-	// Sanitize filename to prevent path traversal
-	safeFilename := filepath.Base(filename)
+// relay pings the target of a PingReq and passes its Pong on to the
+// member that asked
+func (s *Server) relay(requester *net.UDPAddr, req *message.PingReq) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.IndirectProbeTimeout)
+	defer cancel()
+
+	if _, err := s.pingWithin(ctx, req.Target, config.ProbeTimeout); err != nil {
+		return
+	}
 
-	// Check file index cache first
-	s.fileIndexMutex.RLock()
-	_, found := s.fileIndex[safeFilename]
-	s.fileIndexMutex.RUnlock()
+	pong := &message.Pong{Nonce: req.Nonce, Timestamp: req.Timestamp, Updates: s.Cluster.Gossip(config.MaxPiggyback)}
+	s.write(requester, pong.Marshal())
+}
 
-	if found {
-		return true
+// sync sends our full membership state to a member, split over as many
+// datagrams as it takes. With reply set the member answers with its own.
+func (s *Server) sync(addr *net.UDPAddr, reply bool) {
+	for _, updates := range message.SplitUpdates(s.Cluster.State(), config.UDPBufferSize-config.MessageHeaderSize) {
+		s.write(addr, (&message.Sync{Reply: reply, From: s.ID, Updates: updates}).Marshal())
+		// Only the first chunk asks for the state back
+		reply = false
 	}
+	s.write(addr, s.summary().Marshal())
+}
 
-	// Rebuild index and check again (file might have been added)
-	s.rebuildFileIndex()
+// Locate asks the peers that may have name, a file name or content link,
+// for it and returns the ones that answer within the collect window as the
+// providers of a download request. It returns ErrNotFound when nobody
+// answers in time and is safe to call concurrently.
+func (s *Server) Locate(ctx context.Context, name string) (client.Request, error) {
+	id, req, done := s.register(name)
+	defer done()
 
-	s.fileIndexMutex.RLock()
-	_, found = s.fileIndex[safeFilename]
-	s.fileIndexMutex.RUnlock()
+	// Our own request may come back to us through other peers
+	s.routes.add(id, nil, time.Now())
 
-	return found
+	waitCtx, cancel := context.WithTimeout(ctx, s.waitingDuration)
+	defer cancel()
+
+	hash, byHash := index.ParseLink(name)
+
+	// Providers are asked directly, everyone else may forward the request
+	// to the peers they know
+	var msg, flood, item string
+	var key dht.Key
+	origin := s.Cluster.Self().Addr
+	if byHash {
+		msg = (&message.GetHash{ID: id, Hash: hash}).Marshal()
+		flood = (&message.GetHash{ID: id, Hash: hash, TTL: s.TTL, Origin: origin}).Marshal()
+		item = hash
+		key = dht.ContentKey(hash)
+	} else {
+		msg = (&message.Get{ID: id, Name: name}).Marshal()
+		flood = (&message.Get{ID: id, Name: name, TTL: s.TTL, Origin: origin}).Marshal()
+		item = filepath.Base(name)
+		key = dht.NameKey(item)
+	}
+
+	// The request first goes to the providers the DHT knows of, then to the
+	// members whose file summary matches and then to everyone. Records and
+	// summaries may be stale or wrong, so each stage that gets no answer in
+	// time is followed by the next.
+	var stages []stage
+	if providers := s.findProviders(waitCtx, key); len(providers) > 0 {
+		stages = append(stages, stage{name: "provider from the DHT", send: func() {
+			s.log.Infof("Sending file request %s for '%s' to %d provider(s) found in the DHT", id, name, len(providers))
+			for _, provider := range providers {
+				if addr, err := net.ResolveUDPAddr("udp", provider); err == nil {
+					s.write(addr, msg)
+				}
+			}
+		}})
+	} else {
+		s.log.Debugf("No providers of '%s' in the DHT", name)
+	}
+	if targets := s.targets(item); len(targets) > 0 && len(targets) < s.Cluster.Size() {
+		stages = append(stages, stage{name: "member whose summary matches", send: func() {
+			s.log.Infof("Sending file request %s for '%s' to the %d of %d peer(s) whose summary matches",
+				id, name, len(targets), s.Cluster.Size())
+			for _, addr := range targets {
+				s.write(addr, msg)
+			}
+		}})
+	}
+	stages = append(stages, stage{send: func() {
+		s.log.Infof("Broadcasting file request %s for '%s' to %d peer(s), TTL %d", id, name, s.Cluster.Size(), s.TTL)
+		if err := s.Cluster.Broadcast(s.conn, flood); err != nil {
+			s.log.Errorf("File request broadcast error: %v", err)
+		}
+	}})
+
+	wait := s.answerWait()
+	fallback := time.NewTimer(wait)
+	defer fallback.Stop()
+
+	stages[0].send()
+
+	var first *message.File
+	var firstReply reply
+	for first == nil {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return client.Request{}, ctx.Err()
+			}
+			return client.Request{}, fmt.Errorf("%w: no peer responded with '%s' within %v", ErrNotFound, name, s.waitingDuration)
+		case <-fallback.C:
+			if len(stages) == 1 {
+				continue
+			}
+			s.log.Infof("No %s answered file request %s within %v", stages[0].name, id, wait)
+			stages = stages[1:]
+			stages[0].send()
+			fallback.Reset(wait)
+		case firstReply = <-req.replies:
+			first, _ = firstReply.file()
+		}
+	}
+
+	offers := reputation.Rank(s.collect(ctx, req, firstReply))
+	s.log.Successf("%d peer(s) have file '%s' (%d bytes)", len(offers), req.name, first.Size)
+
+	providers := make([]string, 0, len(offers))
+	for i, offer := range offers {
+		s.log.Debugf("Provider #%d %s: RTT %v, %d upload(s), score %.2f", i+1, offer.Addr, offer.RTT, offer.Load, offer.Score)
+		providers = append(providers, offer.Addr)
+	}
+
+	request := client.Request{
+		Name:      req.name,
+		Size:      first.Size,
+		Hash:      first.Hash,
+		Root:      first.Root,
+		Providers: providers,
+	}
+	if byHash {
+		request.Name = first.Name
+		request.ByHash = true
+	}
+
+	return request, nil
 }
 
-// rebuildFileIndex scans the folder and rebuilds the file index
-func (s *Server) rebuildFileIndex() {
-	s.fileIndexMutex.Lock()
-	defer s.fileIndexMutex.Unlock()
+// stage is one round of sending a file request, name says whom it reaches
+type stage struct {
+	name string
+	send func()
+}
 
-	// Clear existing index
-	s.fileIndex = make(map[string]string)
+// answerWait is how long a file request sent to particular peers waits for
+// one of them to answer before it is spread further. Peers delay their
+// answer to a requester without a score by up to their response delay,
+// which is taken to be ours.
+func (s *Server) answerWait() time.Duration {
+	return s.ResponseDelay + config.StageWait
+}
 
-	err := filepath.Walk(s.folder, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files with errors
+// collect gathers the providers that reply within the collect window after
+// first, along with what we know to rank them by. Peers advertising a
+// different hash hold another version of the file and are left out so
+// chunks from them can't be mixed in.
+func (s *Server) collect(ctx context.Context, req *search, first reply) []reputation.Offer {
+	seen := make(map[string]bool)
+	providers := make([]reputation.Offer, 0)
+	firstFile, _ := first.file()
+
+	add := func(r reply) {
+		file, ok := r.file()
+		if !ok {
+			return
 		}
 
-		if !info.IsDir() {
-			s.fileIndex[info.Name()] = path
+		// Replies relayed back from peers we don't know carry the address
+		serverAddr := file.Addr
+		if serverAddr == "" {
+			serverAddr = fmt.Sprintf("%s:%d", r.addr.IP.String(), file.TCPPort)
+		}
+		if seen[serverAddr] {
+			return
+		}
+		if file.Hash != firstFile.Hash {
+			s.log.Warnf("Peer %s has a different version of '%s' (%d bytes), skipping",
+				serverAddr, req.name, file.Size)
+			return
 		}
 
-		return nil
-	})
+		seen[serverAddr] = true
+		providers = append(providers, s.offer(serverAddr, file.Load))
 
-	if err != nil {
-		pterm.Error.Printf("Error rebuilding file index: %v\n", err)
+		s.log.Infof("Peer %s has file '%s'", serverAddr, req.name)
+	}
+
+	add(first)
+
+	window := time.NewTimer(s.collectDuration)
+	defer window.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return providers
+		case <-window.C:
+			return providers
+		case r := <-req.replies:
+			add(r)
+		}
 	}
 }
 
-// GetFilePath returns the full path for a filename if it exists
-func (s *Server) GetFilePath(filename string) (string, bool) {
-	safeFilename := filepath.Base(filename)
+// Query broadcasts a search to the cluster and returns every file the
+// peers list within the collect window. It is safe to call concurrently.
+func (s *Server) Query(ctx context.Context, query string) []Hit {
+	id, req, done := s.register(query)
+	defer done()
+
+	s.log.Debugf("Broadcasting search %s for '%s'", id, query)
+
+	msg := (&message.Search{ID: id, Query: query}).Marshal()
+	if err := s.Cluster.Broadcast(s.conn, msg); err != nil {
+		s.log.Errorf("Search broadcast error: %v", err)
+	}
+
+	window := time.NewTimer(s.collectDuration)
+	defer window.Stop()
+
+	hits := make([]Hit, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return hits
+		case <-window.C:
+			return hits
+		case r := <-req.replies:
+			result, ok := r.msg.(*message.Result)
+			if !ok {
+				continue
+			}
+			for _, match := range result.Matches {
+				hits = append(hits, Hit{Peer: r.addr.String(), Match: match})
+			}
+		}
+	}
+}
+
+// Browse fetches the catalog of shared files of a single peer page by
+// page. A lost page is requested again a few times before giving up.
+func (s *Server) Browse(ctx context.Context, peer string) ([]message.Match, error) {
+	addr, err := net.ResolveUDPAddr("udp", peer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve address %s: %w", peer, err)
+	}
+
+	id, req, done := s.register(peer)
+	defer done()
 
-	s.fileIndexMutex.RLock()
-	path, found := s.fileIndex[safeFilename]
-	s.fileIndexMutex.RUnlock()
+	matches := make([]message.Match, 0)
+	pages := 1
 
-	return path, found
+	for page := 0; page < pages; page++ {
+		catalog, err := s.catalogPage(ctx, req, addr, id, page)
+		if err != nil {
+			return matches, err
+		}
+
+		pages = catalog.Pages
+		matches = append(matches, catalog.Matches...)
+	}
+
+	return matches, nil
 }
 
-func (s *Server) addToPrior(addr string) {
-	s.priorMutex.Lock()
-	defer s.priorMutex.Unlock()
+// catalogPage requests one page of a peer's catalog and waits for it
+func (s *Server) catalogPage(ctx context.Context, req *search, addr *net.UDPAddr, id string, page int) (*message.Catalog, error) {
+	msg := (&message.List{ID: id, Page: page}).Marshal()
+
+	for attempt := 0; attempt <= config.ListPageRetries; attempt++ {
+		s.write(addr, msg)
 
-	if !contains(s.prior, addr) {
-		s.prior = append(s.prior, addr)
+		timeout := time.NewTimer(config.ListPageTimeout)
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				timeout.Stop()
+				return nil, ctx.Err()
+			case <-timeout.C:
+				waiting = false
+			case r := <-req.replies:
+				if catalog, ok := r.msg.(*message.Catalog); ok && catalog.Page == page {
+					timeout.Stop()
+					return catalog, nil
+				}
+			}
+		}
+
+		s.log.Debugf("Page %d of %s's catalog timed out (attempt %d)", page, addr.String(), attempt+1)
 	}
+
+	return nil, fmt.Errorf("peer %s did not send page %d of its catalog", addr.String(), page)
 }
 
-func (s *Server) isPrior(addr string) bool {
-	s.priorMutex.RLock()
-	defer s.priorMutex.RUnlock()
+// Ping sends a Ping to a peer and waits for its Pong, returning the round
+// trip time. The outcome is recorded in the peer's record.
+func (s *Server) Ping(ctx context.Context, peer string) (time.Duration, error) {
+	return s.pingWithin(ctx, peer, config.PingTimeout)
+}
 
-	return contains(s.prior, addr)
+func (s *Server) pingWithin(ctx context.Context, peer string, within time.Duration) (time.Duration, error) {
+	rtt, err := s.ping(ctx, peer, within)
+	s.Cluster.Pinged(peer, rtt, err == nil)
+	return rtt, err
 }
 
-// BroadcastDiscovery manually triggers a discovery broadcast
-func (s *Server) BroadcastDiscovery() {
-	list := s.Cluster.List()
-	msg := (&message.Discover{List: list}).Marshal()
-	if err := s.Cluster.Broadcast(s.conn, msg); err != nil {
-		pterm.Error.Printf("Discovery broadcast error: %v\n", err)
+func (s *Server) ping(ctx context.Context, peer string, within time.Duration) (time.Duration, error) {
+	addr, err := net.ResolveUDPAddr("udp", peer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve address %s: %w", peer, err)
 	}
+
+	nonce, req, done := s.register(peer)
+	defer done()
+
+	ping := &message.Ping{Nonce: nonce, Timestamp: time.Now().UnixNano(), Updates: s.Cluster.Gossip(config.MaxPiggyback)}
+	s.write(addr, ping.Marshal())
+
+	timeout := time.NewTimer(within)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timeout.C:
+			return 0, fmt.Errorf("peer %s did not answer within %v", peer, within)
+		case r := <-req.replies:
+			if pong, ok := r.msg.(*message.Pong); ok {
+				return time.Since(time.Unix(0, pong.Timestamp)), nil
+			}
+		}
+	}
+}
+
+// Search checks if a file exists in the shared folder
+func (s *Server) Search(filename string) bool {
+	_, found := s.index.Get(filename)
+	return found
+}
+
+// GetFilePath returns the full path for a filename if it exists
+func (s *Server) GetFilePath(filename string) (string, bool) {
+	entry, found := s.index.Get(filename)
+	return entry.Path, found
 }
 
 // Close shuts down the UDP server
 func (s *Server) Close() error {
 	s.DiscoveryTicker.Stop()
+	s.ProbeTicker.Stop()
+	if s.Beacon != nil {
+		s.Beacon.Ticker.Stop()
+	}
 	if s.conn != nil {
 		return s.conn.Close()
 	}
 	return nil
 }
-
-// contains checks if a string slice contains a specific item
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/bloom"
+	"github.com/1995parham-teaching/P2P/internal/cluster"
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/dht"
+	"github.com/1995parham-teaching/P2P/internal/identity"
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/logger"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// freePort returns a UDP port nobody listens on
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// addr returns the loopback address of a port
+func addr(port int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// start runs a server on port sharing folder that joins the given peers,
+// after configure, if set, had its say. Nothing is served over TCP, File
+// replies advertise tcpPort all the same.
+func start(t *testing.T, port int, tcpPort int, folder string, configure func(*Server), peers ...string) *Server {
+	t.Helper()
+
+	id, err := identity.Load(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(id, "127.0.0.1", port, cluster.New(peers, logger.Discard),
+		time.NewTicker(time.Hour), time.NewTicker(time.Hour),
+		20*time.Second, 200*time.Millisecond, index.New(folder, logger.Discard), logger.Discard)
+	s.TCPPort = tcpPort
+	if configure != nil {
+		configure(s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, 2)
+	go func() {
+		if err := s.Up(ctx); err != nil {
+			t.Errorf("Up() error = %v", err)
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		s.Gossip(ctx)
+		done <- struct{}{}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		_ = s.Close()
+		<-done
+		<-done
+	})
+
+	<-s.ready
+	return s
+}
+
+// converge waits until s knows n alive members along with their summaries
+func converge(t *testing.T, s *Server, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		members := s.Cluster.Select(func(p cluster.Peer) bool {
+			return p.State == message.Alive && p.ID != "" && p.Summary != nil
+		})
+		if len(members) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d member(s) joined, want %d", len(members), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLocateWithStaleProviders(t *testing.T) {
+	portA, portB, portC := freePort(t), freePort(t), freePort(t)
+
+	// Only b shares the file
+	folderB := t.TempDir()
+	if err := os.WriteFile(filepath.Join(folderB, "data.bin"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := start(t, portB, 4001, folderB, nil)
+	c := start(t, portC, 4002, t.TempDir(), nil)
+	a := start(t, portA, 4000, t.TempDir(), nil, addr(portB), addr(portC))
+	converge(t, a, 2)
+
+	// The records name c, which doesn't have the file, and a node that
+	// isn't a member at all
+	key := dht.NameKey("data.bin")
+	expires := time.Now().Add(time.Minute)
+	for _, s := range []*Server{b, c} {
+		s.providers.Add(key, addr(portC), expires)
+		s.providers.Add(key, "127.0.0.1:9", expires)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if providers := a.findProviders(ctx, key); !slices.Equal(providers, []string{addr(portC)}) {
+		t.Errorf("findProviders() = %v, want only the alive member %s", providers, addr(portC))
+	}
+
+	begin := time.Now()
+	req, err := a.Locate(ctx, "data.bin")
+	if err != nil {
+		t.Fatalf("Locate() error = %v", err)
+	}
+	if !slices.Equal(req.Providers, []string{"127.0.0.1:4001"}) {
+		t.Errorf("Locate() providers = %v, want b's TCP server", req.Providers)
+	}
+	if elapsed := time.Since(begin); elapsed >= a.waitingDuration {
+		t.Errorf("Locate() took %v, it should have fallen back to the summaries", elapsed)
+	}
+}
+
+func TestLocateWaitsForDelayedProviders(t *testing.T) {
+	portA, portB, portC := freePort(t), freePort(t), freePort(t)
+	// Longer than StageWait on its own
+	delay := config.StageWait + time.Second
+
+	folderB := t.TempDir()
+	if err := os.WriteFile(filepath.Join(folderB, "data.bin"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := &recorder{Logger: logger.Discard}
+	delayed := func(s *Server) { s.ResponseDelay = delay }
+
+	b := start(t, portB, 4001, folderB, delayed)
+	c := start(t, portC, 4002, t.TempDir(), delayed)
+	a := start(t, portA, 4000, t.TempDir(), func(s *Server) {
+		delayed(s)
+		s.log = log
+	}, addr(portB), addr(portC))
+	converge(t, a, 2)
+
+	key := dht.NameKey("data.bin")
+	for _, s := range []*Server{b, c} {
+		s.providers.Add(key, addr(portB), time.Now().Add(time.Minute))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// b makes a stranger wait, which mustn't count as the provider failing
+	begin := time.Now()
+	req, err := a.Locate(ctx, "data.bin")
+	if err != nil {
+		t.Fatalf("Locate() error = %v", err)
+	}
+	if !slices.Equal(req.Providers, []string{"127.0.0.1:4001"}) {
+		t.Errorf("Locate() providers = %v, want b's TCP server", req.Providers)
+	}
+	if elapsed := time.Since(begin); elapsed < delay {
+		t.Errorf("Locate() took %v, b should have delayed its answer by %v", elapsed, delay)
+	}
+
+	if log.has("No provider from the DHT answered") {
+		t.Error("Locate() fell back while the provider was still delaying its answer")
+	}
+}
+
+func TestLocateFloodsWhenSummariesMiss(t *testing.T) {
+	portA, portB, portC := freePort(t), freePort(t), freePort(t)
+
+	folderB := t.TempDir()
+	if err := os.WriteFile(filepath.Join(folderB, "data.bin"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := &recorder{Logger: logger.Discard}
+	b := start(t, portB, 4001, folderB, nil)
+	c := start(t, portC, 4002, t.TempDir(), nil)
+	a := start(t, portA, 4000, t.TempDir(), func(s *Server) { s.log = log }, addr(portB), addr(portC))
+	converge(t, a, 2)
+
+	// a's summary of b is stale and the one of c a false positive
+	stale := bloom.New(1, config.SummaryFalsePositive, config.SummaryMaxBits)
+	a.Cluster.Summarize(addr(portB), b.ID, stale)
+	wrong := bloom.New(1, config.SummaryFalsePositive, config.SummaryMaxBits)
+	wrong.Add("data.bin")
+	a.Cluster.Summarize(addr(portC), c.ID, wrong)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := a.Locate(ctx, "data.bin")
+	if err != nil {
+		t.Fatalf("Locate() error = %v", err)
+	}
+	if !slices.Equal(req.Providers, []string{"127.0.0.1:4001"}) {
+		t.Errorf("Locate() providers = %v, want b's TCP server", req.Providers)
+	}
+	if !log.has("No member whose summary matches answered") {
+		t.Error("Locate() found the file without falling back from the summaries")
+	}
+}
+
+func TestSummarySentWhenFilesChange(t *testing.T) {
+	portA, portB := freePort(t), freePort(t)
+
+	folderB := t.TempDir()
+	b := start(t, portB, 4001, folderB, nil)
+	a := start(t, portA, 4000, t.TempDir(), nil, addr(portB))
+	converge(t, a, 1)
+	converge(t, b, 1)
+
+	if err := os.WriteFile(filepath.Join(folderB, "new.bin"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b.index.Rebuild()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if p, ok := a.Cluster.Lookup(addr(portB)); ok && p.Summary != nil && p.Summary.Test("new.bin") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("b's summary at a doesn't list the new file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recorder is a Logger that keeps the info messages
+type recorder struct {
+	logger.Logger
+
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (r *recorder) Infof(format string, args ...any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+// has reports whether an info message starting with prefix was logged
+func (r *recorder) has(prefix string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.ContainsFunc(r.messages, func(m string) bool { return strings.HasPrefix(m, prefix) })
+}
+
+func TestAdvertiseSkipsLoopback(t *testing.T) {
+	s := start(t, freePort(t), 4000, t.TempDir(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The node listens on 127.0.0.1, nobody it finds could reach it
+	if err := s.Advertise(ctx); err != nil || ctx.Err() != nil {
+		t.Errorf("Advertise() = %v after %v, want it to return at once", err, ctx.Err())
+	}
+}
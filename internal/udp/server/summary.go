@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net"
+
+	"github.com/1995parham-teaching/P2P/internal/bloom"
+	"github.com/1995parham-teaching/P2P/internal/cluster"
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// summary builds the Bloom filter of the names and content hashes of the
+// shared files that goes along with our membership state, from the index as
+// it was last scanned
+func (s *Server) summary() *message.Summary {
+	entries := s.index.Entries()
+
+	filter := bloom.New(2*len(entries), config.SummaryFalsePositive, config.SummaryMaxBits)
+	for _, entry := range entries {
+		filter.Add(entry.Name)
+		filter.Add(entry.Hash)
+	}
+
+	return &message.Summary{From: s.ID, Filter: filter.String()}
+}
+
+// resummarize sends our summary to every member once the shared files
+// changed, so they don't rule out the new ones until the next state
+// exchange
+func (s *Server) resummarize() {
+	s.log.Debugf("Shared files changed, sending our summary to %d member(s)", s.Cluster.Size())
+	if err := s.Cluster.Broadcast(s.conn, s.summary().Marshal()); err != nil {
+		s.log.Errorf("Summary broadcast error: %v", err)
+	}
+}
+
+// summarize records the file summary a peer sent
+func (s *Server) summarize(summary *message.Summary, remoteAddr *net.UDPAddr) {
+	filter, err := bloom.Parse(summary.Filter)
+	if err != nil {
+		s.log.Debugf("Ignoring file summary from %s: %v", remoteAddr.String(), err)
+		return
+	}
+	s.Cluster.Summarize(remoteAddr.String(), summary.From, filter)
+}
+
+// targets returns the addresses of the members whose summary says they may
+// share a file with the given name or content hash
+func (s *Server) targets(item string) []*net.UDPAddr {
+	peers := s.Cluster.Select(func(p cluster.Peer) bool { return p.MayHave(item) })
+
+	addrs := make([]*net.UDPAddr, 0, len(peers))
+	for _, p := range peers {
+		if addr, err := net.ResolveUDPAddr("udp", p.Addr); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
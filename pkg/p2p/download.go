@@ -0,0 +1,123 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/tcp/client"
+	udp "github.com/1995parham-teaching/P2P/internal/udp/server"
+)
+
+var (
+	// ErrNotFound is returned when no peer has the requested file
+	ErrNotFound = udp.ErrNotFound
+	// ErrIntegrity is returned when downloaded content doesn't match its
+	// hash
+	ErrIntegrity = client.ErrIntegrity
+	// ErrInProgress is returned when the same content is already being
+	// downloaded
+	ErrInProgress = client.ErrInProgress
+)
+
+// AttemptsError is returned when every attempt to download a file failed
+type AttemptsError = client.AttemptsError
+
+// Progress is how far a download got
+type Progress struct {
+	// ID tells the downloads of a node apart, even ones of the same query
+	ID uint64
+	// Query is what was passed to Download
+	Query string
+	// Received is the number of bytes verified, counting the ones resumed
+	// from an earlier download
+	Received int64
+	Size     int64
+	// Attempt is the number of the attempt in progress, starting at 1
+	Attempt int
+}
+
+// Result describes a downloaded file
+type Result struct {
+	File
+	// Path is where the file was saved
+	Path string
+	// Attempts is how many times the download was tried
+	Attempts int
+}
+
+// Download finds the peers that have query, a file name or content link,
+// and downloads the file from them into the shared folder. Its progress
+// and result go to the node's Events as well. When every attempt fails the
+// peers are searched for again, since the ones that answered may have gone,
+// up to DownloadSearches times.
+//
+// It returns ErrNotFound when no peer has the file, an *AttemptsError when
+// they all failed to serve it and the context's error when ctx is done or
+// the node stops first. It is safe to call concurrently.
+func (n *Node) Download(ctx context.Context, query string) (*Result, error) {
+	id := n.downloads.Add(1)
+	result, err := n.download(ctx, id, query)
+	n.events.emit(func(e Events) { e.DownloadFinished(id, query, result, err) })
+	return result, err
+}
+
+func (n *Node) download(ctx context.Context, id uint64, query string) (*Result, error) {
+	ctx, cancel, err := n.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	progress := func(p client.Progress) {
+		update := Progress{ID: id, Query: query, Received: p.Received, Size: p.Size, Attempt: p.Attempt}
+		n.events.emit(func(e Events) { e.DownloadProgress(update) })
+	}
+
+	var (
+		attempts int
+		lastErr  error
+	)
+
+	for search := 1; search <= config.DownloadSearches; search++ {
+		req, err := n.udpServer.Locate(ctx, query)
+		if err != nil {
+			// The peers that failed us before are gone as well
+			if lastErr != nil && ctx.Err() == nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		req.Progress = progress
+
+		result, err := n.tcpClient.Download(ctx, req)
+		if err == nil {
+			// Other peers can get the file from us right away, requests are
+			// answered from the index without rescanning the folder
+			n.spawn(func() {
+				n.index.Rebuild()
+				n.udpServer.PublishFile(n.ctx, result.Name, result.Hash)
+			})
+
+			return &Result{
+				File:     newFile(result.Name, result.Size, result.Hash),
+				Path:     result.Path,
+				Attempts: attempts + result.Attempts,
+			}, nil
+		}
+
+		var failed *client.AttemptsError
+		if !errors.As(err, &failed) {
+			return nil, err
+		}
+		attempts += failed.Attempts
+		failed.Attempts = attempts
+		lastErr = failed
+
+		if search < config.DownloadSearches {
+			n.log.Warnf("Every attempt to download '%s' failed, searching for peers again", query)
+		}
+	}
+
+	return nil, lastErr
+}
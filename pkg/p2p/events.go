@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"sync"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+)
+
+// Events hears about what happens on a node. The methods are called one at
+// a time and in order from a goroutine of the node, so a slow handler holds
+// up the ones after it.
+type Events interface {
+	// MemberChanged is called when a member joins the cluster, is
+	// suspected to have failed, recovers or dies
+	MemberChanged(Member)
+	// DownloadProgress is called every time a chunk of a download is
+	// verified
+	DownloadProgress(Progress)
+	// DownloadFinished is called when a download ends, with the result or
+	// the error Download returned. id is the one its progress carried.
+	DownloadFinished(id uint64, query string, result *Result, err error)
+}
+
+// NopEvents ignores every event, embed it to handle only some of them
+type NopEvents struct{}
+
+func (NopEvents) MemberChanged(Member) {}
+
+func (NopEvents) DownloadProgress(Progress) {}
+
+func (NopEvents) DownloadFinished(uint64, string, *Result, error) {}
+
+// dispatcher delivers events to the handler from a single goroutine
+type dispatcher struct {
+	queue    chan func(Events)
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDispatcher(handler Events) *dispatcher {
+	d := &dispatcher{
+		queue: make(chan func(Events), config.EventQueueSize),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-d.done:
+				return
+			case event := <-d.queue:
+				event(handler)
+			}
+		}
+	}()
+
+	return d
+}
+
+// emit queues an event, waiting for room unless the node stops
+func (d *dispatcher) emit(event func(Events)) {
+	select {
+	case <-d.done:
+	case d.queue <- event:
+	}
+}
+
+// offer queues an event if there is room, for callers that must not block
+func (d *dispatcher) offer(event func(Events)) bool {
+	select {
+	case d.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop drops the queued events and delivers no more, calling it again does
+// nothing
+func (d *dispatcher) stop() {
+	d.stopOnce.Do(func() { close(d.done) })
+}
@@ -0,0 +1,163 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/message"
+)
+
+// LinkPrefix starts the content links Download accepts in place of a name
+const LinkPrefix = index.LinkPrefix
+
+// File is a shared file
+type File struct {
+	Name string
+	Size int64
+	// Hash is the hex encoded SHA-256 of the content and Link the content
+	// link that downloads it whatever its name
+	Hash string
+	Link string
+}
+
+func newFile(name string, size int64, hash string) File {
+	return File{Name: name, Size: size, Hash: hash, Link: index.Link(hash)}
+}
+
+// Hit is a file found in the cluster, with the peers that share it
+type Hit struct {
+	File
+	Peers []string
+}
+
+// Shared returns the files the node shares
+func (n *Node) Shared() []File {
+	n.index.Rebuild()
+	entries := n.index.Entries()
+
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, newFile(entry.Name, entry.Size, entry.Hash))
+	}
+	return files
+}
+
+// Share copies the file at path into the shared folder, unless it is there
+// already, and returns it as the other peers see it. The members get the
+// new summary and the file's provider records go to the DHT right away.
+func (n *Node) Share(path string) (File, error) {
+	name := filepath.Base(path)
+	target := filepath.Join(n.folder, name)
+
+	same, err := samePath(path, target)
+	if err != nil {
+		return File{}, err
+	}
+	if !same {
+		if _, err := os.Stat(target); err == nil {
+			return File{}, fmt.Errorf("a different %s is shared already", name)
+		}
+		if err := copyFile(path, target); err != nil {
+			return File{}, err
+		}
+	}
+
+	// A rescan that finds the file sends the members our summary
+	n.index.Rebuild()
+	entry, ok := n.index.Get(name)
+	if !ok {
+		return File{}, fmt.Errorf("%s can't be shared", name)
+	}
+
+	n.spawn(func() { n.udpServer.PublishFile(n.ctx, entry.Name, entry.Hash) })
+	return newFile(entry.Name, entry.Size, entry.Hash), nil
+}
+
+// Search asks the cluster for files whose name matches query, a glob
+// pattern or a substring, and returns them with the peers that share them,
+// the same content on several peers listed once
+func (n *Node) Search(ctx context.Context, query string) ([]Hit, error) {
+	ctx, cancel, err := n.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	hits := make([]Hit, 0)
+	byHash := make(map[string]int)
+	for _, hit := range n.udpServer.Query(ctx, query) {
+		if i, ok := byHash[hit.Hash]; ok {
+			hits[i].Peers = append(hits[i].Peers, hit.Peer)
+			continue
+		}
+
+		byHash[hit.Hash] = len(hits)
+		hits = append(hits, Hit{File: fromMatch(hit.Match), Peers: []string{hit.Peer}})
+	}
+	return hits, nil
+}
+
+// Browse returns the files the member at addr shares. When the catalog
+// doesn't arrive in full it returns the files received along with the
+// error.
+func (n *Node) Browse(ctx context.Context, addr string) ([]File, error) {
+	ctx, cancel, err := n.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	matches, err := n.udpServer.Browse(ctx, addr)
+
+	files := make([]File, 0, len(matches))
+	for _, match := range matches {
+		files = append(files, fromMatch(match))
+	}
+	return files, err
+}
+
+func fromMatch(m message.Match) File {
+	return newFile(m.Name, m.Size, m.Hash)
+}
+
+// samePath reports whether a and b name the same file, b may not exist
+func samePath(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	if !infoA.Mode().IsRegular() {
+		return false, fmt.Errorf("%s is not a regular file", a)
+	}
+
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, nil
+	}
+	return os.SameFile(infoA, infoB), nil
+}
+
+// copyFile copies src to a new file at dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
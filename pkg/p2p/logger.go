@@ -0,0 +1,29 @@
+package p2p
+
+import (
+	"log/slog"
+
+	"github.com/1995parham-teaching/P2P/internal/logger"
+)
+
+// Logger receives what the services of a node have to say. Messages are
+// printf style and don't end with a newline.
+type Logger = logger.Logger
+
+var (
+	// DiscardLogger drops every message
+	DiscardLogger = logger.Discard
+	// TerminalLogger prints every message with pterm, for interactive use
+	TerminalLogger = logger.Terminal
+)
+
+// TerminalLevelLogger prints the messages at level or above with pterm
+func TerminalLevelLogger(level slog.Level) Logger {
+	return logger.TerminalLevel(level)
+}
+
+// SlogLogger writes every message as a record of l, successes at the info
+// level with success=true
+func SlogLogger(l *slog.Logger) Logger {
+	return logger.Slog(l)
+}
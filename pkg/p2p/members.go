@@ -0,0 +1,149 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/cluster"
+	"github.com/1995parham-teaching/P2P/internal/config"
+	"github.com/1995parham-teaching/P2P/internal/message"
+	"github.com/1995parham-teaching/P2P/internal/reputation"
+)
+
+// ScoreHalfLife is how long it takes the counts behind a peer's score to
+// halve
+const ScoreHalfLife = config.ScoreHalfLife
+
+// State is the state of a cluster member
+type State int
+
+const (
+	// Alive members answer our probes, or did so recently
+	Alive State = iota
+	// Suspect members failed a probe and have a while to refute it
+	Suspect
+	// Dead members were removed from the cluster
+	Dead
+)
+
+func (s State) String() string {
+	switch s {
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	default:
+		return "alive"
+	}
+}
+
+// Member is a peer of the cluster
+type Member struct {
+	// Addr is the address the peer advertises
+	Addr string
+	// ID, Version and TCPPort are empty until the peer gossips its meta
+	ID      string
+	Version int
+	TCPPort int
+	State   State
+	// LastSeen is when a datagram from the peer last arrived
+	LastSeen time.Time
+	// RTT and Reachable are the outcome of the last ping, at Pinged, which
+	// is zero when the peer was never pinged
+	RTT       time.Duration
+	Reachable bool
+	Pinged    time.Time
+}
+
+func newMember(p cluster.Peer) Member {
+	state := Alive
+	switch p.State {
+	case message.Suspect:
+		state = Suspect
+	case message.Dead:
+		state = Dead
+	}
+
+	return Member{
+		Addr:      p.Addr,
+		ID:        p.ID,
+		Version:   p.Version,
+		TCPPort:   p.TCPPort,
+		State:     state,
+		LastSeen:  p.LastSeen,
+		RTT:       p.RTT,
+		Reachable: p.Reachable,
+		Pinged:    p.Pinged,
+	}
+}
+
+// Members returns the members of the cluster
+func (n *Node) Members() []Member {
+	peers := n.udpServer.Cluster.Peers()
+
+	members := make([]Member, 0, len(peers))
+	for _, p := range peers {
+		members = append(members, newMember(p))
+	}
+	return members
+}
+
+// Ping pings the member at addr and returns the round trip time
+func (n *Node) Ping(ctx context.Context, addr string) (time.Duration, error) {
+	ctx, cancel, err := n.scope(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return n.udpServer.Ping(ctx, addr)
+}
+
+// Score is the reputation of a peer that served us files
+type Score struct {
+	// Peer is the ID of the peer, or its address when the ID is unknown,
+	// and Addr its address
+	Peer string
+	Addr string
+	// Transfers and Failures count the ranges it served and failed to,
+	// decayed by age
+	Transfers float64
+	Failures  float64
+	// Throughput is the average in bytes per second over the transfers
+	Throughput float64
+	Score      float64
+	// Delay is how long a request from the peer waits for our answer
+	Delay time.Duration
+}
+
+// Scores returns the reputation of the peers that served us files, the
+// best first
+func (n *Node) Scores() []Score {
+	records := n.udpServer.Scores.Records(time.Now())
+
+	scores := make([]Score, 0, len(records))
+	for _, record := range records {
+		// Peers are scored by ID when it is known
+		addr := record.Peer
+		if peer, ok := n.udpServer.Cluster.LookupID(record.Peer); ok {
+			addr = peer.Addr
+		}
+
+		scores = append(scores, Score{
+			Peer:       record.Peer,
+			Addr:       addr,
+			Transfers:  record.Transfers,
+			Failures:   record.Failures,
+			Throughput: record.Throughput,
+			Score:      record.Score,
+			Delay:      reputation.Delay(record.Score, n.udpServer.ResponseDelay),
+		})
+	}
+	return scores
+}
+
+// ResponseDelay returns how long a request from a peer without any score
+// waits for our answer
+func (n *Node) ResponseDelay() time.Duration {
+	return n.udpServer.ResponseDelay
+}
@@ -0,0 +1,317 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/cluster"
+	"github.com/1995parham-teaching/P2P/internal/identity"
+	"github.com/1995parham-teaching/P2P/internal/index"
+	"github.com/1995parham-teaching/P2P/internal/tcp/client"
+	tcp "github.com/1995parham-teaching/P2P/internal/tcp/server"
+	udp "github.com/1995parham-teaching/P2P/internal/udp/server"
+)
+
+// ErrNotRunning is returned by the methods that need the network when the
+// node wasn't started or has stopped
+var ErrNotRunning = errors.New("node is not running")
+
+// Node is a peer of the cluster that shares the files of a folder and
+// downloads files from the other peers into it
+type Node struct {
+	udpServer *udp.Server
+	tcpServer *tcp.Server
+	tcpClient *client.Client
+	folder    string
+	index     *index.Index
+	events    *dispatcher
+	log       Logger
+	downloads atomic.Uint64 // the last download ID handed out
+
+	// The peer table is stored in peers every persist and on shutdown,
+	// peers not seen for stale are forgotten
+	peers   string
+	persist time.Duration
+	stale   time.Duration
+
+	// Context for graceful shutdown, running is set between Start and Stop
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	running  bool
+	mutex    sync.Mutex
+	stopOnce sync.Once
+}
+
+// New creates a node that shares opts.Folder, it doesn't touch the network
+// until Start
+func New(opts Options) (*Node, error) {
+	opts = opts.withDefaults()
+	if opts.Folder == "" {
+		return nil, errors.New("no shared folder given")
+	}
+
+	log := opts.Logger
+
+	clu := cluster.New(opts.Peers, log)
+	clu.SetTimeouts(cluster.Timeouts{
+		Suspect:   opts.Suspect,
+		Tombstone: opts.Tombstone,
+	})
+	idx := index.New(opts.Folder, log)
+
+	id, err := identity.Load(opts.Folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+
+	peers := filepath.Join(opts.Folder, identity.Dir, "peers.json")
+	if restored, err := clu.Load(peers, opts.Stale); err != nil {
+		log.Warnf("Starting without the stored peers: %v", err)
+	} else if restored > 0 {
+		log.Infof("Restored %d peer(s) from the last run", restored)
+	}
+
+	udpServer := udp.New(
+		id,
+		opts.Host,
+		opts.Port,
+		clu,
+		time.NewTicker(opts.Period),
+		time.NewTicker(opts.Probe),
+		opts.Waiting,
+		opts.Collect,
+		idx,
+		log,
+	)
+
+	if opts.Beacon != "" {
+		beacon, err := udp.NewBeacon(opts.Beacon, opts.BeaconPort, opts.BeaconPeriod)
+		if err != nil {
+			return nil, err
+		}
+		udpServer.Beacon = beacon
+	}
+	udpServer.MDNS = opts.MDNS
+	udpServer.TTL = opts.TTL
+	udpServer.ResponseDelay = opts.ResponseDelay
+
+	tcpServer := tcp.New(idx, opts.Host, log)
+	udpServer.Load = tcpServer.Load
+
+	tcpClient := client.New(opts.Folder, log)
+	tcpClient.Reporter = udpServer
+
+	events := newDispatcher(opts.Events)
+	clu.SetNotify(func(p cluster.Peer) {
+		member := newMember(p)
+		if !events.offer(func(e Events) { e.MemberChanged(member) }) {
+			log.Debugf("Event queue full, dropping the change of %s", p.Addr)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Node{
+		udpServer: udpServer,
+		tcpServer: tcpServer,
+		tcpClient: tcpClient,
+		folder:    opts.Folder,
+		index:     idx,
+		events:    events,
+		log:       log,
+		peers:     peers,
+		persist:   opts.Persist,
+		stale:     opts.Stale,
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Start brings up the servers and the membership protocol in the
+// background, Stop shuts them down
+func (n *Node) Start() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.running {
+		return errors.New("node is already running")
+	}
+	if n.ctx.Err() != nil {
+		return errors.New("node was stopped")
+	}
+
+	// The UDP server advertises the port the TCP server listens on
+	tcpPort, err := n.tcpServer.Listen()
+	if err != nil {
+		return fmt.Errorf("failed to start TCP server: %w", err)
+	}
+	n.udpServer.TCPPort = tcpPort
+
+	// Requests can go out as soon as Start returns
+	if err := n.udpServer.Listen(); err != nil {
+		_ = n.tcpServer.Close()
+		return err
+	}
+	n.running = true
+
+	// Start TCP server
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.tcpServer.Up(n.ctx); err != nil {
+			n.log.Errorf("TCP server error: %v", err)
+		}
+	}()
+
+	// Start UDP server
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.udpServer.Up(n.ctx); err != nil {
+			n.log.Errorf("UDP server error: %v", err)
+		}
+	}()
+
+	// Start the membership protocol
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.udpServer.Gossip(n.ctx)
+	}()
+
+	// Announce the node on the local network
+	if n.udpServer.Beacon != nil {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			if err := n.udpServer.Announce(n.ctx); err != nil {
+				n.log.Errorf("Beacon error: %v", err)
+			}
+		}()
+	}
+
+	// Advertise the node over mDNS
+	if n.udpServer.MDNS {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			if err := n.udpServer.Advertise(n.ctx); err != nil {
+				n.log.Errorf("mDNS error: %v", err)
+			}
+		}()
+	}
+
+	// Publish provider records for our files in the DHT
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.udpServer.Publish(n.ctx)
+	}()
+
+	// Store the peer table periodically
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.persistPeers()
+	}()
+
+	return nil
+}
+
+// Stop gracefully shuts the node down: the downloads in progress are
+// canceled, the servers closed and the peer table stored. A stopped node
+// can't be started again. Calling Stop again, or on a node that was never
+// started, is fine.
+func (n *Node) Stop() {
+	n.stopOnce.Do(n.stop)
+}
+
+func (n *Node) stop() {
+	n.mutex.Lock()
+	running := n.running
+	n.running = false
+	n.mutex.Unlock()
+
+	n.cancel()
+
+	// Close servers, which also stops the tickers New created
+	_ = n.tcpServer.Close()
+	_ = n.udpServer.Close()
+
+	// Wait for goroutines to finish
+	n.wg.Wait()
+	n.events.stop()
+
+	if running {
+		n.savePeers()
+	}
+}
+
+// ID returns the stable ID of the node
+func (n *Node) ID() string {
+	return n.udpServer.ID
+}
+
+// Folder returns the shared folder
+func (n *Node) Folder() string {
+	return n.folder
+}
+
+// scope returns a context that is done when either ctx or the node is, or
+// ErrNotRunning when the node isn't running
+func (n *Node) scope(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if !n.running {
+		return nil, nil, ErrNotRunning
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(n.ctx, cancel)
+	return ctx, func() { stop(); cancel() }, nil
+}
+
+// spawn runs f in the background, Stop waits for it. Nothing runs once the
+// node is stopping.
+func (n *Node) spawn(f func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if !n.running {
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+// persistPeers stores the peer table every persist period until shutdown
+func (n *Node) persistPeers() {
+	ticker := time.NewTicker(n.persist)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.savePeers()
+		}
+	}
+}
+
+func (n *Node) savePeers() {
+	if err := n.udpServer.Cluster.Save(n.peers, n.stale); err != nil {
+		n.log.Errorf("Failed to store peers: %v", err)
+	}
+}
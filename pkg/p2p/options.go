@@ -0,0 +1,137 @@
+package p2p
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/internal/config"
+)
+
+// Options configures a node. Zero fields take the defaults of
+// configs/config.example.yml.
+type Options struct {
+	// Folder is the shared folder, downloads are saved into it as well
+	Folder string
+	// Peers are the addresses of the initial cluster members, it may be
+	// empty when Beacon or MDNS finds them on the local network
+	Peers []string
+
+	// Host and Port are where the UDP server listens, the TCP server
+	// listens on Host as well, on a port the OS picks
+	Host string
+	Port int
+
+	// Period is how often the full membership state is exchanged with a
+	// random member, Probe how often a member is pinged
+	Period time.Duration
+	Probe  time.Duration
+	// Suspect is how long a suspected member has to refute before it is
+	// declared dead, and Tombstone how long a dead member is remembered
+	Suspect   time.Duration
+	Tombstone time.Duration
+
+	// Waiting is how long a download waits for the first peer that has the
+	// file and Collect how long it waits for more after it
+	Waiting time.Duration
+	Collect time.Duration
+	// TTL is how many hops a broadcast file request travels
+	TTL int
+	// ResponseDelay is how long a request from a peer without any score
+	// waits for our answer, a negative delay answers everyone right away
+	ResponseDelay time.Duration
+
+	// Beacon is "multicast" or "broadcast" to announce the node with
+	// beacons and join the nodes heard on the local network, empty to
+	// disable it. BeaconPort is where beacons go and BeaconPeriod how often
+	// they are sent.
+	Beacon       string
+	BeaconPort   int
+	BeaconPeriod time.Duration
+	// MDNS advertises and finds nodes as DNS-SD services over mDNS
+	MDNS bool
+
+	// Persist is how often the peer table is stored in the shared folder,
+	// peers not seen for Stale are left out of it
+	Persist time.Duration
+	Stale   time.Duration
+
+	// Events, if set, hears about what happens on the node
+	Events Events
+	// Logger receives the log of the node, slog's default logger when nil
+	Logger Logger
+}
+
+// FromConfig returns the options a configuration describes
+func FromConfig(cfg config.Config) Options {
+	return Options{
+		Host:          cfg.Host,
+		Port:          cfg.Port,
+		Period:        seconds(cfg.DiscoveryPeriod),
+		Probe:         seconds(cfg.ProbePeriod),
+		Suspect:       seconds(cfg.SuspectTime),
+		Tombstone:     seconds(cfg.TombstoneTime),
+		Waiting:       seconds(cfg.WaitingTime),
+		Collect:       seconds(cfg.CollectTime),
+		TTL:           cfg.SearchTTL,
+		ResponseDelay: seconds(cfg.ResponseDelay),
+		Beacon:        cfg.Beacon,
+		BeaconPort:    cfg.BeaconPort,
+		BeaconPeriod:  seconds(cfg.BeaconPeriod),
+		MDNS:          cfg.MDNS,
+		Persist:       seconds(cfg.PersistPeriod),
+		Stale:         seconds(cfg.StaleTime),
+	}
+}
+
+// withDefaults returns the options with every zero field set to its default
+func (o Options) withDefaults() Options {
+	defaults := FromConfig(config.Defaults())
+
+	or := func(value *time.Duration, fallback time.Duration) {
+		if *value <= 0 {
+			*value = fallback
+		}
+	}
+
+	if o.Host == "" {
+		o.Host = defaults.Host
+	}
+	if o.Port == 0 {
+		o.Port = defaults.Port
+	}
+	if o.TTL <= 0 {
+		o.TTL = defaults.TTL
+	}
+	if o.BeaconPort == 0 {
+		o.BeaconPort = defaults.BeaconPort
+	}
+	or(&o.Period, defaults.Period)
+	or(&o.Probe, defaults.Probe)
+	or(&o.Suspect, defaults.Suspect)
+	or(&o.Tombstone, defaults.Tombstone)
+	or(&o.Waiting, defaults.Waiting)
+	or(&o.Collect, defaults.Collect)
+	or(&o.BeaconPeriod, defaults.BeaconPeriod)
+	or(&o.Persist, defaults.Persist)
+	or(&o.Stale, defaults.Stale)
+
+	switch {
+	case o.ResponseDelay == 0:
+		o.ResponseDelay = defaults.ResponseDelay
+	case o.ResponseDelay < 0:
+		o.ResponseDelay = 0
+	}
+
+	if o.Events == nil {
+		o.Events = NopEvents{}
+	}
+	if o.Logger == nil {
+		o.Logger = SlogLogger(slog.Default())
+	}
+
+	return o
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
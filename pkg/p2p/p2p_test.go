@@ -0,0 +1,178 @@
+package p2p_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-teaching/P2P/pkg/p2p"
+)
+
+// recorder keeps the download events of a node
+type recorder struct {
+	p2p.NopEvents
+
+	mutex    sync.Mutex
+	progress []p2p.Progress
+	finished []error
+	ids      []uint64
+}
+
+func (r *recorder) DownloadProgress(p p2p.Progress) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.progress = append(r.progress, p)
+}
+
+func (r *recorder) DownloadFinished(id uint64, _ string, _ *p2p.Result, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.finished = append(r.finished, err)
+	r.ids = append(r.ids, id)
+}
+
+// freePort returns a UDP port nobody listens on
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// start runs a node on port that knows the one on peer
+func start(t *testing.T, port, peer int, events p2p.Events) *p2p.Node {
+	t.Helper()
+
+	n, err := p2p.New(p2p.Options{
+		Folder:        t.TempDir(),
+		Peers:         []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(peer))},
+		Host:          "127.0.0.1",
+		Port:          port,
+		Waiting:       2 * time.Second,
+		Collect:       200 * time.Millisecond,
+		ResponseDelay: -1,
+		Events:        events,
+		Logger:        p2p.DiscardLogger,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := n.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+func TestShareAndDownload(t *testing.T) {
+	portA, portB := freePort(t), freePort(t)
+	events := &recorder{}
+	a := start(t, portA, portB, events)
+	b := start(t, portB, portA, nil)
+
+	content := bytes.Repeat([]byte("p2p"), 1<<20)
+	source := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(source, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	shared, err := b.Share(source)
+	if err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+	if files := b.Shared(); len(files) != 1 || files[0] != shared {
+		t.Fatalf("Shared() = %+v, want %+v", files, shared)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	hits, err := a.Search(ctx, "*.bin")
+	if err != nil || len(hits) != 1 || hits[0].File != shared {
+		t.Fatalf("Search() = %+v, %v, want the shared file", hits, err)
+	}
+
+	result, err := a.Download(ctx, shared.Link)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if result.File != shared || result.Attempts != 1 {
+		t.Errorf("Download() = %+v, want %+v in one attempt", result, shared)
+	}
+	if got, err := os.ReadFile(result.Path); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("downloaded file differs from the shared one (%v)", err)
+	}
+
+	if _, err := a.Download(ctx, "missing.bin"); !errors.Is(err, p2p.ErrNotFound) {
+		t.Errorf("Download() error = %v for a missing file, want ErrNotFound", err)
+	}
+
+	// Events are delivered in the background
+	deadline := time.Now().Add(time.Second)
+	for {
+		events.mutex.Lock()
+		finished, progress, ids := len(events.finished), events.progress, events.ids
+		events.mutex.Unlock()
+
+		if finished == 2 {
+			last := progress[len(progress)-1]
+			if last.Query != shared.Link || last.Received != shared.Size || last.ID != ids[0] {
+				t.Errorf("last progress = %+v, want all %d bytes of download %d", last, shared.Size, ids[0])
+			}
+			if ids[0] == ids[1] {
+				t.Errorf("both downloads finished with ID %d, want them told apart", ids[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d download(s) finished, want 2", finished)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNotRunning(t *testing.T) {
+	n, err := p2p.New(p2p.Options{Folder: t.TempDir(), Logger: p2p.DiscardLogger})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer n.Stop()
+
+	if _, err := n.Download(context.Background(), "a.txt"); !errors.Is(err, p2p.ErrNotRunning) {
+		t.Errorf("Download() error = %v before Start, want ErrNotRunning", err)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	n := start(t, freePort(t), freePort(t), nil)
+
+	n.Stop()
+	n.Stop()
+
+	if _, err := n.Download(context.Background(), "a.txt"); !errors.Is(err, p2p.ErrNotRunning) {
+		t.Errorf("Download() error = %v after Stop, want ErrNotRunning", err)
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	n, err := p2p.New(p2p.Options{Folder: t.TempDir(), Logger: p2p.DiscardLogger})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	n.Stop()
+	n.Stop()
+
+	if err := n.Start(); err == nil {
+		t.Error("Start() after Stop succeeded, want an error")
+	}
+}